@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"github.com/Gammanik/distributed-storage/internal/api"
 	"log"
@@ -10,7 +11,16 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Gammanik/distributed-storage/internal/chunker"
+	"github.com/Gammanik/distributed-storage/internal/cluster"
+	"github.com/Gammanik/distributed-storage/internal/gc"
+	"github.com/Gammanik/distributed-storage/internal/janitor"
 	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/nodestats"
+	"github.com/Gammanik/distributed-storage/internal/placement"
+	"github.com/Gammanik/distributed-storage/internal/rebalance"
+	"github.com/Gammanik/distributed-storage/internal/reconcile"
+	"github.com/Gammanik/distributed-storage/internal/repair"
 	"github.com/Gammanik/distributed-storage/internal/storage"
 )
 
@@ -18,6 +28,33 @@ var (
 	port        = flag.Int("port", 8080, "HTTP port to listen on")
 	metaDBPath  = flag.String("meta", "/data/meta.db", "Path to metadata database")
 	chunkSize   = flag.Int64("chunk-size", 64<<20, "Default chunk size in bytes")
+	chunking    = flag.String("chunking", "fixed", "Default chunking mode: fixed or cdc")
+	cdcMinSize  = flag.Int64("cdc-min", chunker.DefaultCDCMinSize, "Minimum chunk size for cdc chunking")
+	cdcAvgSize  = flag.Int64("cdc-avg", chunker.DefaultCDCAvgSize, "Average chunk size for cdc chunking")
+	cdcMaxSize  = flag.Int64("cdc-max", chunker.DefaultCDCMaxSize, "Maximum chunk size for cdc chunking")
+	durability  = flag.String("durability", "replication", "Default durability mode: replication or erasure")
+	replicas    = flag.Int("replicas", 2, "Number of chunk copies for replication mode")
+	minReplicas = flag.Int("min-replicas", 2, "Minimum number of acknowledged chunk copies (write concern)")
+	ecData      = flag.Int("ec-data", 4, "Number of data shards for erasure mode")
+	ecParity    = flag.Int("ec-parity", 2, "Number of parity shards for erasure mode")
+	repairEvery = flag.Duration("repair-interval", 10*time.Minute, "Interval between repair passes (0 runs repair only on demand)")
+	repairRate  = flag.Float64("repair-rate", 10, "Maximum number of chunks repaired per second (0 is unlimited)")
+	gcEvery     = flag.Duration("gc-interval", time.Minute, "Interval between garbage collection passes (0 runs GC only after deletes)")
+	uploadTTL   = flag.Duration("upload-ttl", 24*time.Hour, "Inactivity period after which incomplete uploads are removed")
+	prefetch    = flag.Int("prefetch-depth", 4, "Number of chunks fetched ahead while serving a download")
+	prefetchMem = flag.Int64("prefetch-memory", 1<<30, "Memory budget in bytes for prefetched chunks across all downloads")
+	uploadJobs  = flag.Int("upload-workers", 4, "Number of chunks of one upload sent to storage nodes in parallel")
+	uploadMem   = flag.Int64("upload-memory", 1<<30, "Memory budget in bytes for chunks waiting to be uploaded across all uploads")
+	hedgeReads  = flag.Bool("hedge-reads", true, "Send a second read to another replica when the first is slower than its p95 latency")
+	healthEvery = flag.Duration("health-interval", 5*time.Second, "Interval between storage node health checks")
+	nodeTTL     = flag.Duration("node-ttl", 30*time.Second, "Time without heartbeats after which a self-registered storage node is removed")
+	placeMode   = flag.String("placement", "weighted", "Chunk placement: weighted (by free space) or rendezvous (uniform)")
+	highWater   = flag.Float64("high-water", 0.9, "Disk fill ratio above which a storage node receives no new chunks")
+	domainLevel = flag.String("failure-domain", "host", "Failure domain replicas must span: zone, rack or host")
+	moveRate    = flag.Float64("rebalance-rate", 10, "Maximum number of chunks moved per second by the rebalancer (0 is unlimited)")
+	moveBytes   = flag.Int64("rebalance-bandwidth", 0, "Maximum bytes per second copied by the rebalancer (0 is unlimited)")
+	syncEvery   = flag.Duration("reconcile-interval", time.Hour, "Interval between storage node inventory reconciliations (0 runs them only on demand)")
+	orphanGrace = flag.Duration("orphan-grace", 24*time.Hour, "Time a chunk unknown to metadata stays on a storage node before it is deleted")
 	storagePool = flag.String("storage-pool", "http://storage1:9000,http://storage2:9000", "Comma-separated list of storage nodes")
 )
 
@@ -31,24 +68,152 @@ func main() {
 	}
 	defer store.Close()
 
-	// Разбиваем список серверов хранения
-	nodes := strings.Split(*storagePool, ",")
-	for i, node := range nodes {
-		nodes[i] = strings.TrimSpace(node)
+	// Создаем реестр серверов хранения и запускаем проверку их состояния
+	nodes := cluster.New(strings.Split(*storagePool, ","))
+	nodes.Store = store
+	nodes.Interval = *healthEvery
+	nodes.HeartbeatTTL = *nodeTTL
+	nodes.HighWater = *highWater
+	if err := nodes.Load(); err != nil {
+		log.Fatalf("Failed to load storage nodes: %v", err)
 	}
+	go nodes.Run(context.Background())
+
+	// Выбираем способ размещения чанков
+	var chunkPlacement placement.Placement
+	switch *placeMode {
+	case "weighted":
+		chunkPlacement = placement.Weighted{Weights: nodes.Weights}
+	case "rendezvous":
+		chunkPlacement = placement.Rendezvous{}
+	default:
+		log.Fatalf("Unknown placement: %s", *placeMode)
+	}
+	switch *domainLevel {
+	case placement.LevelZone, placement.LevelRack, placement.LevelHost:
+	default:
+		log.Fatalf("Unknown failure domain: %s", *domainLevel)
+	}
+
+	// Проверяем параметры разбиения на чанки
+	switch *chunking {
+	case chunker.ModeFixed, chunker.ModeCDC:
+	default:
+		log.Fatalf("Unknown chunking mode: %s", *chunking)
+	}
+	if *cdcMinSize <= 0 || *cdcMinSize > *cdcAvgSize || *cdcAvgSize > *cdcMaxSize {
+		log.Fatalf("Invalid cdc chunk sizes: min %d, avg %d, max %d (need 0 < min <= avg <= max)", *cdcMinSize, *cdcAvgSize, *cdcMaxSize)
+	}
+
+	// Копии разносятся по зонам, стойкам и хостам
+	chunkPlacement = placement.Spread{Base: chunkPlacement, Domains: nodes.Domains}
 
 	// Создаем обработчик файлов
+	tracker := nodestats.NewTracker()
 	fileHandler := &api.FileHandler{
 		Store:       store,
 		Storage:     storage.New(),
 		StoragePool: nodes,
+		Placement:   chunkPlacement,
+		DomainLevel: *domainLevel,
 		ChunkSize:   *chunkSize,
+		Chunking:    *chunking,
+		CDCMinSize:  *cdcMinSize,
+		CDCAvgSize:  *cdcAvgSize,
+		CDCMaxSize:  *cdcMaxSize,
+		Durability: metastore.DurabilityPolicy{
+			Mode:         *durability,
+			Replicas:     *replicas,
+			MinReplicas:  *minReplicas,
+			DataShards:   *ecData,
+			ParityShards: *ecParity,
+		},
+		PrefetchDepth:  *prefetch,
+		PrefetchMemory: *prefetchMem,
+		UploadWorkers:  *uploadJobs,
+		UploadMemory:   *uploadMem,
+		Tracker:        tracker,
+		HedgeReads:     *hedgeReads,
+	}
+
+	// Запускаем фоновое восстановление недореплицированных чанков
+	repairer := repair.New(store, fileHandler.Storage, nodes)
+	repairer.Placement = chunkPlacement
+	repairer.DomainLevel = *domainLevel
+	repairer.Replicas = *replicas
+	repairer.Rate = *repairRate
+	repairer.Interval = *repairEvery
+	go repairer.Run(context.Background())
+
+	// Запускаем сборщик мусора для чанков удаленных файлов
+	collector := gc.New(store, fileHandler.Storage)
+	collector.Interval = *gcEvery
+	fileHandler.OnDelete = collector.Trigger
+	go collector.Run(context.Background())
+
+	// Запускаем очистку брошенных загрузок
+	uploadJanitor := janitor.New(store)
+	uploadJanitor.TTL = *uploadTTL
+	if *uploadTTL/2 < uploadJanitor.Interval {
+		uploadJanitor.Interval = *uploadTTL / 2
+	}
+	uploadJanitor.OnExpire = collector.Trigger
+	go uploadJanitor.Run(context.Background())
+
+	// Продолжаем перебалансировку и вывод серверов, прерванные перезапуском
+	rebalancer := rebalance.New(store, fileHandler.Storage, nodes)
+	rebalancer.Placement = chunkPlacement
+	rebalancer.Rate = *moveRate
+	rebalancer.Bandwidth = *moveBytes
+	if err := rebalancer.Resume(); err != nil {
+		log.Printf("Failed to resume rebalance: %v", err)
+	}
+	rebalancer.ResumeDrains()
+
+	// Запускаем сверку содержимого серверов с метаданными
+	reconciler := reconcile.New(store, fileHandler.Storage, nodes)
+	reconciler.Interval = *syncEvery
+	reconciler.Grace = *orphanGrace
+	reconciler.OnMissing = repairer.Enqueue
+	reconciler.OnOrphans = collector.Trigger
+	go reconciler.Run(context.Background())
+
+	adminHandler := &api.AdminHandler{
+		Repairer:   repairer,
+		Collector:  collector,
+		Janitor:    uploadJanitor,
+		Rebalancer: rebalancer,
+		Reconciler: reconciler,
+	}
+
+	clusterHandler := &api.ClusterHandler{
+		Nodes:      nodes,
+		Tracker:    tracker,
+		Rebalancer: rebalancer,
+		Repairer:   repairer,
 	}
 
 	// Регистрируем обработчики HTTP запросов
 	http.HandleFunc("/upload", fileHandler.Upload)
 	http.HandleFunc("/download", fileHandler.Download)
 	http.HandleFunc("/info", fileHandler.GetFileInfo)
+	http.HandleFunc("DELETE /files/{id}", fileHandler.Delete)
+	http.HandleFunc("POST /uploads", fileHandler.CreateUpload)
+	http.HandleFunc("GET /uploads/{id}", fileHandler.GetUpload)
+	http.HandleFunc("PUT /uploads/{id}/chunks/{index}", fileHandler.PutUploadChunk)
+	http.HandleFunc("POST /uploads/{id}/complete", fileHandler.CompleteUpload)
+	http.HandleFunc("/admin/repair", adminHandler.Repair)
+	http.HandleFunc("/admin/gc", adminHandler.GC)
+	http.HandleFunc("/admin/rebalance", adminHandler.Rebalance)
+	http.HandleFunc("/admin/reconcile", adminHandler.Reconcile)
+	http.HandleFunc("GET /admin/uploads", adminHandler.PendingUploads)
+	http.HandleFunc("GET /cluster/nodes", clusterHandler.ListNodes)
+	http.HandleFunc("POST /cluster/heartbeat", clusterHandler.Heartbeat)
+	http.HandleFunc("POST /cluster/corrupt", clusterHandler.ReportCorrupt)
+	http.HandleFunc("POST /admin/nodes", clusterHandler.AddNode)
+	http.HandleFunc("DELETE /admin/nodes/{id}", clusterHandler.RemoveNode)
+	http.HandleFunc("POST /admin/nodes/{id}/drain", clusterHandler.DrainNode)
+	http.HandleFunc("GET /admin/nodes/{id}/drain", clusterHandler.DrainProgress)
 
 	// Настраиваем и запускаем HTTP сервер
 	server := &http.Server{
@@ -59,6 +224,6 @@ func main() {
 	}
 
 	log.Printf("REST server starting on :%d", *port)
-	log.Printf("Connected to %d storage nodes", len(nodes))
+	log.Printf("Connected to %d storage nodes", len(nodes.Nodes()))
 	log.Fatal(server.ListenAndServe())
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Gammanik/distributed-storage/internal/storage"
+)
+
+// Ограничения размера страницы списка чанков
+const (
+	defaultListLimit = 1000
+	maxListLimit     = 10000
+)
+
+// errStopListing останавливает обход чанков
+var errStopListing = errors.New("stop listing")
+
+// listChunksHandler возвращает чанки узла по возрастанию ID.
+// По умолчанию отдает страницу JSON: {"chunks": [...], "next": "<id>"},
+// где next - значение after для следующей страницы (пусто на последней).
+// С format=ndjson или Accept: application/x-ndjson отдает потоком все чанки
+// после after, по одному JSON объекту на строку. Поток завершается записью
+// {"end": true, "count": N}; если ее нет, список оборван.
+func listChunksHandler(store *chunkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		after := r.URL.Query().Get("after")
+
+		if r.URL.Query().Get("format") == "ndjson" || r.Header.Get("Accept") == "application/x-ndjson" {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			out := bufio.NewWriter(w)
+			enc := json.NewEncoder(out)
+			count := 0
+			err := store.walk(after, func(e storage.ChunkEntry) error {
+				count++
+				return enc.Encode(e)
+			})
+			if err != nil {
+				// Заголовки уже отправлены: без завершающей записи клиент
+				// увидит оборванный поток
+				log.Printf("Failed to stream chunk list: %v", err)
+				return
+			}
+			enc.Encode(storage.ChunkListEnd{End: true, Count: count})
+			out.Flush()
+			return
+		}
+
+		limit := defaultListLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxListLimit)
+		}
+
+		// Читаем на один чанк больше, чтобы узнать, есть ли следующая страница
+		page := storage.ChunkPage{Chunks: []storage.ChunkEntry{}}
+		more := false
+		err := store.walk(after, func(e storage.ChunkEntry) error {
+			if len(page.Chunks) == limit {
+				more = true
+				return errStopListing
+			}
+			page.Chunks = append(page.Chunks, e)
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopListing) {
+			http.Error(w, "Failed to list chunks", http.StatusInternalServerError)
+			log.Printf("Failed to list chunks: %v", err)
+			return
+		}
+		if more {
+			page.Next = page.Chunks[len(page.Chunks)-1].ID
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Gammanik/distributed-storage/internal/storage"
+)
+
+func TestStreamChunksComplete(t *testing.T) {
+	s, err := openChunkStore(t.TempDir(), syncNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for i := 0; i < 20; i++ {
+		ids = append(ids, putChunk(t, s, []byte(fmt.Sprintf("chunk %d", i))))
+	}
+	sort.Strings(ids)
+
+	srv := httptest.NewServer(listChunksHandler(s))
+	defer srv.Close()
+
+	for _, after := range []string{"", ids[9], "g"} {
+		var got []string
+		err := storage.New().StreamChunks(srv.URL, after, func(e storage.ChunkEntry) error {
+			got = append(got, e.ID)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("after %q: %v", after, err)
+		}
+
+		var want []string
+		for _, id := range ids {
+			if id > after {
+				want = append(want, id)
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("after %q: got %d chunks, want %d", after, len(got), len(want))
+		}
+	}
+}
+
+func TestStreamChunksTruncated(t *testing.T) {
+	entry := `{"id":"aaaa","size":1,"mtime":"2024-01-01T00:00:00Z"}` + "\n"
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no end record", entry + entry},
+		{"cut mid record", entry + `{"id":"bb`},
+		{"count mismatch", entry + `{"end":true,"count":2}` + "\n"},
+		{"empty body", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				strings.NewReader(tt.body).WriteTo(w)
+			}))
+			defer srv.Close()
+
+			err := storage.New().StreamChunks(srv.URL, "", func(e storage.ChunkEntry) error { return nil })
+			if err == nil {
+				t.Fatal("truncated chunk list accepted as complete")
+			}
+		})
+	}
+}
@@ -4,6 +4,8 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -11,7 +13,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"syscall"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -20,8 +23,25 @@ var (
 	port    = flag.Int("port", 9000, "HTTP port to listen on")
 	dataDir = flag.String("data", "./data", "Directory to store chunks")
 	nodeID  = flag.String("id", "", "Node ID (default: from environment NODE_ID)")
+
+	register  = flag.String("register", "", "Comma-separated list of REST servers to register with")
+	advertise = flag.String("advertise", "", "URL under which REST servers reach this node (default: http://<hostname>:<port>)")
+	heartbeat = flag.Duration("heartbeat-interval", 10*time.Second, "Interval between heartbeats sent to REST servers")
+
+	syncMode = flag.String("durability", syncFileDir, "How chunk writes are flushed before they are acknowledged: none, fsync or fsync+dirsync")
+
+	zone = flag.String("zone", "", "Availability zone of this node (failure domain label)")
+	rack = flag.String("rack", "", "Rack of this node (failure domain label)")
+	host = flag.String("host", "", "Physical or docker host of this node (failure domain label)")
+
+	scrubEvery  = flag.Duration("scrub-interval", 24*time.Hour, "Pause between passes re-verifying chunk hashes (0 disables scrubbing)")
+	scrubRate   = flag.Int64("scrub-rate", 10<<20, "Maximum bytes per second read by the scrubber (0 is unlimited)")
+	scrubNotify = flag.Bool("scrub-notify", true, "Report corrupt chunks to the REST servers from -register")
 )
 
+// chunkScrubber фоновая проверка чанков (nil, если отключена)
+var chunkScrubber *scrubber
+
 func main() {
 	flag.Parse()
 
@@ -34,12 +54,20 @@ func main() {
 		}
 	}
 
-	// Создаем директорию для хранения данных
+	// Открываем хранилище чанков
 	storageDir := filepath.Join(*dataDir, id)
-	if err := os.MkdirAll(storageDir, 0755); err != nil {
-		log.Fatalf("Failed to create storage directory: %v", err)
+	store, err := openChunkStore(storageDir, *syncMode)
+	if err != nil {
+		log.Fatalf("Failed to open storage directory: %v", err)
 	}
 
+	// Переносим чанки из плоской директории прежних версий
+	go func() {
+		if err := store.migrate(); err != nil {
+			log.Printf("Failed to migrate chunks: %v", err)
+		}
+	}()
+
 	router := mux.NewRouter()
 
 	// Обработчик для загрузки чанка
@@ -58,19 +86,15 @@ func main() {
 			return
 		}
 
-		// Создаем путь для сохранения чанка
-		chunkPath := filepath.Join(storageDir, chunkID)
-
 		// Если чанк уже существует, ничего не делаем
-		if _, err := os.Stat(chunkPath); err == nil {
+		if store.has(chunkID) {
 			w.WriteHeader(http.StatusOK)
 			fmt.Fprintln(w, "Chunk already exists")
 			return
 		}
 
 		// Создаем временный файл
-		tmpPath := chunkPath + ".tmp"
-		file, err := os.Create(tmpPath)
+		file, err := store.create(chunkID)
 		if err != nil {
 			http.Error(w, "Failed to create file", http.StatusInternalServerError)
 			log.Printf("Failed to create file: %v", err)
@@ -82,10 +106,11 @@ func main() {
 		h := sha256.New()
 		writer := io.MultiWriter(file, h)
 
-		if _, err := io.Copy(writer, r.Body); err != nil {
+		size, err := io.Copy(writer, r.Body)
+		if err != nil {
 			http.Error(w, "Failed to read data", http.StatusInternalServerError)
 			log.Printf("Failed to read data: %v", err)
-			os.Remove(tmpPath)
+			os.Remove(file.Name())
 			return
 		}
 
@@ -94,15 +119,22 @@ func main() {
 		if actualHash != chunkID {
 			http.Error(w, "Hash mismatch", http.StatusBadRequest)
 			log.Printf("Hash mismatch. Expected: %s, Got: %s", chunkID, actualHash)
-			os.Remove(tmpPath)
+			os.Remove(file.Name())
 			return
 		}
 
-		// Переименовываем временный файл
-		if err := os.Rename(tmpPath, chunkPath); err != nil {
+		// Сбрасываем данные на диск и переименовываем временный файл
+		err = store.commit(chunkID, file, size)
+		if errors.Is(err, errChunkExists) {
+			// Тот же чанк записан параллельным запросом
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintln(w, "Chunk already exists")
+			return
+		}
+		if err != nil {
 			http.Error(w, "Failed to save file", http.StatusInternalServerError)
-			log.Printf("Failed to rename file: %v", err)
-			os.Remove(tmpPath)
+			log.Printf("Failed to save file: %v", err)
+			os.Remove(file.Name())
 			return
 		}
 
@@ -110,9 +142,10 @@ func main() {
 		fmt.Fprintln(w, "Chunk saved")
 	}).Methods("PUT")
 
-	// Обработчик для скачивания чанка
+	// Обработчик для скачивания чанка. Поддерживает HEAD, Range,
+	// If-None-Match/If-Range (ETag равен хешу чанка) и Last-Modified.
 	router.HandleFunc("/chunks/{chunkID}", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "GET" {
+		if r.Method != "GET" && r.Method != "HEAD" {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
@@ -126,17 +159,12 @@ func main() {
 			return
 		}
 
-		// Путь к файлу
-		chunkPath := filepath.Join(storageDir, chunkID)
-
-		// Проверяем существование файла
-		if _, err := os.Stat(chunkPath); os.IsNotExist(err) {
+		// Открываем файл для чтения
+		file, err := store.open(chunkID)
+		if os.IsNotExist(err) {
 			http.Error(w, "Chunk not found", http.StatusNotFound)
 			return
 		}
-
-		// Открываем файл для чтения
-		file, err := os.Open(chunkPath)
 		if err != nil {
 			http.Error(w, "Failed to read chunk", http.StatusInternalServerError)
 			log.Printf("Failed to open file: %v", err)
@@ -144,12 +172,18 @@ func main() {
 		}
 		defer file.Close()
 
-		// Отправляем содержимое файла
-		w.Header().Set("Content-Type", "application/octet-stream")
-		if _, err := io.Copy(w, file); err != nil {
-			log.Printf("Failed to send file: %v", err)
+		info, err := file.Stat()
+		if err != nil {
+			http.Error(w, "Failed to read chunk", http.StatusInternalServerError)
+			log.Printf("Failed to stat file: %v", err)
+			return
 		}
-	}).Methods("GET")
+
+		// Отправляем содержимое файла (целиком или запрошенные диапазоны)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("ETag", `"`+chunkID+`"`)
+		http.ServeContent(w, r, chunkID, info.ModTime(), file)
+	}).Methods("GET", "HEAD")
 
 	// Обработчик для удаления чанка (для администрирования)
 	router.HandleFunc("/chunks/{chunkID}", func(w http.ResponseWriter, r *http.Request) {
@@ -167,17 +201,13 @@ func main() {
 			return
 		}
 
-		// Путь к файлу
-		chunkPath := filepath.Join(storageDir, chunkID)
-
-		// Проверяем существование файла
-		if _, err := os.Stat(chunkPath); os.IsNotExist(err) {
+		// Удаляем файл
+		err := store.remove(chunkID)
+		if os.IsNotExist(err) {
 			http.Error(w, "Chunk not found", http.StatusNotFound)
 			return
 		}
-
-		// Удаляем файл
-		if err := os.Remove(chunkPath); err != nil {
+		if err != nil {
 			http.Error(w, "Failed to delete chunk", http.StatusInternalServerError)
 			log.Printf("Failed to delete file: %v", err)
 			return
@@ -187,34 +217,46 @@ func main() {
 		fmt.Fprintln(w, "Chunk deleted")
 	}).Methods("DELETE")
 
+	// Обработчик списка чанков узла
+	router.HandleFunc("/chunks", listChunksHandler(store)).Methods("GET")
+
 	// Обработчик статуса узла
 	router.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(nodeStatus(id, store))
+	})
 
-		// Получаем информацию о свободном месте
-		var stat syscall.Statfs_t
-		syscall.Statfs(storageDir, &stat)
-
-		// Считаем количество чанков
-		chunks, err := countChunks(storageDir)
-		if err != nil {
-			log.Printf("Failed to count chunks: %v", err)
+	// Адрес, под которым узел известен REST серверам
+	self := *advertise
+	if self == "" {
+		hostname, _ := os.Hostname()
+		self = fmt.Sprintf("http://%s:%d", hostname, *port)
+	}
+	var servers []string
+	if *register != "" {
+		for _, server := range strings.Split(*register, ",") {
+			servers = append(servers, strings.TrimSpace(server))
 		}
+	}
 
-		// Считаем общий размер данных
-		totalSize, err := dirSize(storageDir)
+	// Запускаем фоновую проверку целостности чанков
+	if *scrubEvery > 0 {
+		s, err := newScrubber(store, *scrubEvery, *scrubRate)
 		if err != nil {
-			log.Printf("Failed to calculate storage size: %v", err)
+			log.Fatalf("Failed to create quarantine directory: %v", err)
 		}
+		s.self = self
+		if *scrubNotify {
+			s.notify = servers
+		}
+		chunkScrubber = s
+		go s.run()
+	}
 
-		fmt.Fprintf(w, `{
-			"nodeID": "%s",
-			"status": "online",
-			"chunks": %d,
-			"totalSize": %d,
-			"freeSpace": %d
-		}`, id, chunks, totalSize, stat.Bfree*uint64(stat.Bsize))
-	})
+	// Регистрируемся на REST серверах и отправляем им heartbeat
+	for _, server := range servers {
+		go sendHeartbeats(server, self, id, store, *heartbeat)
+	}
 
 	addr := fmt.Sprintf(":%d", *port)
 	log.Printf("Storage node %s starting on %s", id, addr)
@@ -230,35 +272,3 @@ func isHexString(s string) bool {
 	}
 	return true
 }
-
-// countChunks подсчитывает количество чанков в директории
-func countChunks(dir string) (int, error) {
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		return 0, err
-	}
-
-	count := 0
-	for _, file := range files {
-		if !file.IsDir() && len(file.Name()) == 64 && isHexString(file.Name()) {
-			count++
-		}
-	}
-
-	return count, nil
-}
-
-// dirSize вычисляет общий размер директории
-func dirSize(path string) (int64, error) {
-	var size int64
-	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			size += info.Size()
-		}
-		return nil
-	})
-	return size, err
-}
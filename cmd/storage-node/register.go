@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/cluster"
+	"github.com/Gammanik/distributed-storage/internal/placement"
+)
+
+// nodeStatus собирает состояние узла для /status и heartbeat
+func nodeStatus(id string, store *chunkStore) cluster.NodeStatus {
+	// Получаем информацию о свободном месте
+	var stat syscall.Statfs_t
+	syscall.Statfs(store.dir, &stat)
+
+	// Количество и размер чанков поддерживаются хранилищем
+	chunks, totalSize := store.stats()
+
+	return cluster.NodeStatus{
+		NodeID:    id,
+		Status:    "online",
+		Chunks:    chunks,
+		TotalSize: totalSize,
+		FreeSpace: int64(stat.Bfree * uint64(stat.Bsize)),
+		Capacity:  int64(stat.Blocks * uint64(stat.Bsize)),
+		Domain:    placement.Domain{Zone: *zone, Rack: *rack, Host: *host},
+		Scrub:     chunkScrubber.Status(),
+	}
+}
+
+// sendHeartbeats периодически отправляет REST серверу адрес и состояние узла.
+// Первый heartbeat регистрирует узел.
+func sendHeartbeats(server, self, id string, store *chunkStore, interval time.Duration) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failing := false
+	for {
+		err := postHeartbeat(client, server, self, nodeStatus(id, store))
+		// Пишем в лог только смену состояния, а не каждую неудачу
+		if err != nil && !failing {
+			log.Printf("Failed to send heartbeat to %s: %v", server, err)
+		} else if err == nil && failing {
+			log.Printf("Heartbeats to %s restored", server)
+		}
+		failing = err != nil
+
+		<-ticker.C
+	}
+}
+
+// postHeartbeat отправляет один heartbeat
+func postHeartbeat(client *http.Client, server, self string, status cluster.NodeStatus) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"url":    self,
+		"status": status,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(server+"/cluster/heartbeat", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("heartbeat rejected: %d", resp.StatusCode)
+	}
+	return nil
+}
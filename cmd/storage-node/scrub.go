@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/cluster"
+	"github.com/Gammanik/distributed-storage/internal/storage"
+	"github.com/Gammanik/distributed-storage/internal/utils"
+)
+
+// maxQuarantineList максимум чанков карантина, перечисляемых в /status
+const maxQuarantineList = 100
+
+// scrubber фоновая проверка чанков: перечитывает каждый чанк с ограничением
+// скорости чтения и сверяет SHA-256 содержимого с именем файла. Поврежденные
+// и нечитаемые файлы переносятся в карантин, откуда их не отдают клиентам, а REST
+// серверы получают уведомление, чтобы восстановить чанк из другой копии.
+type scrubber struct {
+	store      *chunkStore
+	quarantine string        // Директория поврежденных чанков
+	interval   time.Duration // Период между проходами
+	rate       int64         // Максимум читаемых байт в секунду (0 - без ограничения)
+	self       string        // URL узла для уведомлений
+	notify     []string      // REST серверы, которым сообщается о повреждениях
+	client     *http.Client
+
+	mu          sync.Mutex
+	status      cluster.ScrubStatus
+	quarantined []cluster.QuarantinedChunk
+}
+
+// newScrubber создает scrubber и загружает список чанков в карантине
+func newScrubber(store *chunkStore, interval time.Duration, rate int64) (*scrubber, error) {
+	s := &scrubber{
+		store:      store,
+		quarantine: filepath.Join(store.dir, "quarantine"),
+		interval:   interval,
+		rate:       rate,
+		client:     &http.Client{Timeout: 5 * time.Second},
+	}
+	if err := os.MkdirAll(s.quarantine, 0755); err != nil {
+		return nil, err
+	}
+
+	// Карантин переживает перезапуск узла
+	files, err := os.ReadDir(s.quarantine)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil || file.IsDir() {
+			continue
+		}
+		s.quarantined = append(s.quarantined, cluster.QuarantinedChunk{ID: file.Name(), Size: info.Size(), DetectedAt: info.ModTime()})
+	}
+	sort.Slice(s.quarantined, func(i, j int) bool { return s.quarantined[i].DetectedAt.Before(s.quarantined[j].DetectedAt) })
+	s.status.Quarantined = len(s.quarantined)
+
+	return s, nil
+}
+
+// run выполняет проходы проверки с периодом interval
+func (s *scrubber) run() {
+	for {
+		if err := s.scrub(); err != nil {
+			log.Printf("Scrub pass failed: %v", err)
+		}
+		time.Sleep(s.interval)
+	}
+}
+
+// Status возвращает состояние проверки для /status
+func (s *scrubber) Status() *cluster.ScrubStatus {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.status
+	// В ответ попадают последние обнаруженные повреждения
+	recent := s.quarantined[max(0, len(s.quarantined)-maxQuarantineList):]
+	st.Chunks = append([]cluster.QuarantinedChunk(nil), recent...)
+	return &st
+}
+
+// update изменяет статус под мьютексом
+func (s *scrubber) update(fn func(st *cluster.ScrubStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.status)
+}
+
+// scrub выполняет один проход по всем чанкам
+func (s *scrubber) scrub() error {
+	s.update(func(st *cluster.ScrubStatus) {
+		st.Running = true
+		st.LastStart = time.Now()
+		st.Checked = 0
+		st.BytesChecked = 0
+	})
+
+	limiter := utils.NewThrottle(s.rate)
+	err := s.store.walk("", func(e storage.ChunkEntry) error {
+		actual, n, err := s.hashFile(e.ID, limiter)
+		if os.IsNotExist(err) {
+			// Чанк удален во время прохода
+			return nil
+		}
+
+		s.update(func(st *cluster.ScrubStatus) {
+			st.Checked++
+			st.BytesChecked += n
+		})
+
+		// Ошибка чтения (например, EIO) означает потерю копии так же, как несовпадение хеша
+		if err != nil || actual != e.ID {
+			s.quarantineChunk(e.ID, actual, e.Size, err)
+		}
+		return nil
+	})
+
+	s.update(func(st *cluster.ScrubStatus) {
+		st.Running = false
+		st.Passes++
+		st.LastFinish = time.Now()
+	})
+	return err
+}
+
+// hashFile читает чанк с ограничением скорости и возвращает хеш содержимого
+func (s *scrubber) hashFile(id string, limiter *utils.Throttle) (string, int64, error) {
+	file, err := s.store.open(id)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	buf := make([]byte, 1<<20)
+	var total int64
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			total += int64(n)
+			limiter.Wait(int64(n))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", total, err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), total, nil
+}
+
+// quarantineChunk переносит поврежденный или нечитаемый (readErr != nil) чанк
+// в карантин и сообщает о нем
+func (s *scrubber) quarantineChunk(id, actual string, size int64, readErr error) {
+	qc := cluster.QuarantinedChunk{ID: id, Size: size, ActualHash: actual}
+	if readErr != nil {
+		qc.ReadError = readErr.Error()
+		log.Printf("Chunk %s is unreadable (%v), moving to quarantine", id, readErr)
+	} else {
+		log.Printf("Chunk %s is corrupted (content hash %s), moving to quarantine", id, actual)
+	}
+
+	if err := s.store.moveOut(id, filepath.Join(s.quarantine, id)); err != nil {
+		log.Printf("Failed to quarantine chunk %s: %v", id, err)
+		return
+	}
+
+	s.mu.Lock()
+	qc.DetectedAt = time.Now()
+	s.quarantined = append(s.quarantined, qc)
+	s.status.Quarantined = len(s.quarantined)
+	s.mu.Unlock()
+
+	for _, server := range s.notify {
+		if err := s.postCorrupt(server, id); err != nil {
+			// Пропавшую копию позже найдет сверка на REST сервере
+			log.Printf("Failed to report corrupt chunk %s to %s: %v", id, server, err)
+		}
+	}
+}
+
+// postCorrupt сообщает REST серверу о поврежденном чанке
+func (s *scrubber) postCorrupt(server, id string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"url":    s.self,
+		"chunks": []string{id},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(server+"/cluster/corrupt", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("report rejected: %d", resp.StatusCode)
+	}
+	return nil
+}
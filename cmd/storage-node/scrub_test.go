@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// scrubSetup создает хранилище, scrubber и REST сервер, собирающий уведомления
+func scrubSetup(t *testing.T) (*chunkStore, *scrubber, func() []string) {
+	t.Helper()
+
+	store, err := openChunkStore(t.TempDir(), syncNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newScrubber(store, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu       sync.Mutex
+		reported []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Chunks []string `json:"chunks"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		reported = append(reported, req.Chunks...)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(server.Close)
+	s.notify = []string{server.URL}
+
+	return store, s, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), reported...)
+	}
+}
+
+func TestScrubQuarantinesCorruptChunk(t *testing.T) {
+	store, s, reported := scrubSetup(t)
+	good := putChunk(t, store, []byte("good chunk"))
+	bad := putChunk(t, store, []byte("bad chunk"))
+	if err := os.WriteFile(store.path(bad), []byte("bit rot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.scrub(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !store.has(good) || store.has(bad) {
+		t.Fatalf("has(good) = %v, has(bad) = %v, want true, false", store.has(good), store.has(bad))
+	}
+	st := s.Status()
+	if st.Checked != 2 || st.Quarantined != 1 || len(st.Chunks) != 1 {
+		t.Fatalf("status = %+v, want 2 checked, 1 quarantined", st)
+	}
+	if qc := st.Chunks[0]; qc.ID != bad || qc.ActualHash == "" || qc.ReadError != "" {
+		t.Errorf("quarantined = %+v, want %s with content hash", qc, bad)
+	}
+	if got := reported(); len(got) != 1 || got[0] != bad {
+		t.Errorf("reported = %q, want [%s]", got, bad)
+	}
+}
+
+func TestScrubQuarantinesUnreadableChunk(t *testing.T) {
+	store, s, reported := scrubSetup(t)
+	bad := putChunk(t, store, []byte("unreadable chunk"))
+
+	// Ссылка на директорию открывается, но чтение из нее завершается ошибкой
+	target := filepath.Join(t.TempDir(), "dir")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(store.path(bad)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, store.path(bad)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.scrub(); err != nil {
+		t.Fatal(err)
+	}
+
+	if store.has(bad) {
+		t.Fatal("unreadable chunk is still served")
+	}
+	if _, err := os.Lstat(filepath.Join(s.quarantine, bad)); err != nil {
+		t.Fatalf("chunk not in quarantine: %v", err)
+	}
+	st := s.Status()
+	if st.Checked != 1 || st.Quarantined != 1 || len(st.Chunks) != 1 {
+		t.Fatalf("status = %+v, want 1 checked, 1 quarantined", st)
+	}
+	if qc := st.Chunks[0]; qc.ID != bad || qc.ReadError == "" {
+		t.Errorf("quarantined = %+v, want %s with read error", qc, bad)
+	}
+	if got := reported(); len(got) != 1 || got[0] != bad {
+		t.Errorf("reported = %q, want [%s]", got, bad)
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/storage"
+)
+
+// errChunkExists возвращается, если чанк уже сохранен
+var errChunkExists = errors.New("chunk already exists")
+
+// Режимы надежности записи чанков
+const (
+	syncNone    = "none"          // Данные остаются в кеше ОС: чанк теряется при сбое питания
+	syncFile    = "fsync"         // Содержимое файла сбрасывается на диск до переименования
+	syncFileDir = "fsync+dirsync" // Дополнительно сбрасывается директория, чтобы сохранилось переименование
+)
+
+// chunkStore хранит чанки узла в директориях по первым байтам хеша:
+// <dir>/ab/cd/abcd...  Так ни в одной директории не оказывается больше
+// нескольких тысяч файлов даже при миллионах чанков. Чанки, сохраненные
+// в плоской директории прежними версиями, переносятся в фоне (migrate);
+// до переноса они доступны по старому пути.
+//
+// Количество и размер чанков считаются один раз при запуске и дальше
+// поддерживаются при записи и удалении, поэтому /status не обходит диск.
+//
+// Чанк записывается во временный файл <id>.*.tmp и переименовывается, только
+// когда хеш содержимого проверен. Насколько запись переживет сбой питания,
+// задает режим sync. Временные файлы, оставшиеся после сбоя, удаляются
+// при запуске.
+type chunkStore struct {
+	dir  string
+	sync string // syncNone, syncFile или syncFileDir
+
+	mu     sync.Mutex // Упорядочивает появление, удаление и перенос файлов
+	chunks int
+	size   int64
+	legacy int // Чанки, еще не перенесенные из плоской директории
+}
+
+// openChunkStore открывает хранилище чанков, удаляет незавершенные записи
+// и подсчитывает чанки
+func openChunkStore(dir, sync string) (*chunkStore, error) {
+	switch sync {
+	case syncNone, syncFile, syncFileDir:
+	default:
+		return nil, fmt.Errorf("unknown sync mode: %s", sync)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &chunkStore{dir: dir, sync: sync}
+	removed := 0
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		depth := strings.Count(rel, string(filepath.Separator))
+
+		if d.IsDir() {
+			// Чанки лежат в корне (старый формат) и на втором уровне
+			if rel != "." && (depth > 1 || len(d.Name()) != 2 || !isHexString(d.Name())) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Запись, прерванная сбоем: ответ об успехе не отправлялся
+		if strings.HasSuffix(d.Name(), ".tmp") {
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+			removed++
+			return nil
+		}
+
+		if !isChunkID(d.Name()) || (depth != 0 && depth != 2) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		s.chunks++
+		s.size += info.Size()
+		if depth == 0 {
+			s.legacy++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if removed > 0 {
+		log.Printf("Removed %d incomplete chunk writes", removed)
+	}
+	return s, nil
+}
+
+// path возвращает путь к чанку в директориях по префиксу хеша
+func (s *chunkStore) path(id string) string {
+	return filepath.Join(s.dir, id[:2], id[2:4], id)
+}
+
+// legacyPath возвращает путь к чанку в плоской директории
+func (s *chunkStore) legacyPath(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+// locate возвращает путь к существующему чанку
+func (s *chunkStore) locate(id string) (string, os.FileInfo, error) {
+	p := s.path(id)
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		if legacy, lerr := os.Stat(s.legacyPath(id)); lerr == nil {
+			return s.legacyPath(id), legacy, nil
+		}
+		// Чанк мог быть перенесен между двумя проверками
+		info, err = os.Stat(p)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return p, info, nil
+}
+
+// has проверяет, что чанк сохранен
+func (s *chunkStore) has(id string) bool {
+	_, _, err := s.locate(id)
+	return err == nil
+}
+
+// open открывает чанк для чтения
+func (s *chunkStore) open(id string) (*os.File, error) {
+	p, _, err := s.locate(id)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(p)
+	if os.IsNotExist(err) && p == s.legacyPath(id) {
+		// Чанк перенесен после locate
+		return os.Open(s.path(id))
+	}
+	return file, err
+}
+
+// create создает временный файл для записи чанка. У параллельных записей
+// одного чанка разные временные файлы.
+func (s *chunkStore) create(id string) (*os.File, error) {
+	p := s.path(id)
+	if err := s.mkdir(filepath.Dir(p)); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(filepath.Dir(p), id+".*.tmp")
+}
+
+// commit сбрасывает на диск и закрывает записанный временный файл, затем
+// переименовывает его в чанк. Если чанк уже появился, временный файл
+// удаляется и возвращается errChunkExists.
+func (s *chunkStore) commit(id string, file *os.File, size int64) error {
+	p := s.path(id)
+
+	// До переименования: иначе после сбоя под именем чанка может оказаться
+	// файл без содержимого
+	if s.sync != syncNone {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.has(id) {
+		os.Remove(file.Name())
+		return errChunkExists
+	}
+	if err := os.Rename(file.Name(), p); err != nil {
+		return err
+	}
+	s.chunks++
+	s.size += size
+
+	// Переименование сохраняется только вместе с директорией
+	if s.sync == syncFileDir {
+		return syncDir(filepath.Dir(p))
+	}
+	return nil
+}
+
+// mkdir создает директорию чанка. В режиме syncFileDir новые директории
+// сбрасываются на диск вместе с родительскими, чтобы чанк в них не потерялся.
+func (s *chunkStore) mkdir(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if s.sync != syncFileDir {
+		return nil
+	}
+	for d := dir; d != s.dir; d = filepath.Dir(d) {
+		if err := syncDir(filepath.Dir(d)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDir сбрасывает на диск содержимое директории
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// remove удаляет чанк
+func (s *chunkStore) remove(id string) error {
+	return s.moveOut(id, "")
+}
+
+// moveOut убирает чанк из хранилища: переносит в dst или удаляет, если dst пуст
+func (s *chunkStore) moveOut(id, dst string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, info, err := s.locate(id)
+	if err != nil {
+		return err
+	}
+	if dst == "" {
+		err = os.Remove(p)
+	} else {
+		err = os.Rename(p, dst)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.chunks--
+	s.size -= info.Size()
+	if p == s.legacyPath(id) {
+		s.legacy--
+	}
+	return nil
+}
+
+// stats возвращает количество и суммарный размер чанков
+func (s *chunkStore) stats() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.chunks, s.size
+}
+
+// migrate переносит чанки из плоской директории в директории по префиксу.
+// Узел продолжает обслуживать запросы во время переноса.
+func (s *chunkStore) migrate() error {
+	s.mu.Lock()
+	pending := s.legacy
+	s.mu.Unlock()
+	if pending == 0 {
+		return nil
+	}
+
+	log.Printf("Migrating %d chunks to the sharded directory layout", pending)
+	start := time.Now()
+
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	moved := 0
+	for _, file := range files {
+		id := file.Name()
+		if file.IsDir() || !isChunkID(id) {
+			continue
+		}
+		if err := s.migrateChunk(id); err != nil {
+			return fmt.Errorf("chunk %s: %w", id, err)
+		}
+		moved++
+		if moved%10000 == 0 {
+			log.Printf("Migrated %d of %d chunks", moved, pending)
+		}
+	}
+
+	log.Printf("Migrated %d chunks in %v", moved, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// migrateChunk переносит один чанк из плоской директории
+func (s *chunkStore) migrateChunk(id string) error {
+	p := s.path(id)
+	if err := s.mkdir(filepath.Dir(p)); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Stat(s.legacyPath(id)); os.IsNotExist(err) {
+		// Удален после чтения директории
+		return nil
+	}
+	if _, err := os.Stat(p); err == nil {
+		// Чанк уже есть в новом месте (и учтен один раз): старая копия лишняя
+		if err := os.Remove(s.legacyPath(id)); err != nil {
+			return err
+		}
+		s.legacy--
+		return nil
+	}
+	if err := os.Rename(s.legacyPath(id), p); err != nil {
+		return err
+	}
+	s.legacy--
+
+	// Сначала новое место: при сбое чанк окажется хотя бы в одном из двух
+	if s.sync == syncFileDir {
+		if err := syncDir(filepath.Dir(p)); err != nil {
+			return err
+		}
+		return syncDir(s.dir)
+	}
+	return nil
+}
+
+// walk вызывает fn для каждого чанка с ID больше after по возрастанию ID.
+// Чанки, еще не перенесенные из плоской директории, идут вперемешку
+// с остальными в общем порядке.
+func (s *chunkStore) walk(after string, fn func(e storage.ChunkEntry) error) error {
+	root, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	// Корень содержит директории первого уровня и чанки старого формата
+	var prefixes []string
+	legacy := make(map[string][]os.DirEntry)
+	for _, entry := range root {
+		name := entry.Name()
+		switch {
+		case entry.IsDir() && len(name) == 2 && isHexString(name):
+			prefixes = append(prefixes, name)
+		case !entry.IsDir() && isChunkID(name):
+			prefix := name[:2]
+			if _, ok := legacy[prefix]; !ok {
+				prefixes = append(prefixes, prefix)
+			}
+			legacy[prefix] = append(legacy[prefix], entry)
+		}
+	}
+	sort.Strings(prefixes)
+
+	seen := make(map[string]bool, len(prefixes))
+	for _, prefix := range prefixes {
+		if seen[prefix] || (after != "" && prefix < after[:min(2, len(after))]) {
+			continue
+		}
+		seen[prefix] = true
+
+		entries := legacy[prefix]
+		inner, err := os.ReadDir(filepath.Join(s.dir, prefix))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, dir := range inner {
+			if !dir.IsDir() || len(dir.Name()) != 2 || !isHexString(dir.Name()) {
+				continue
+			}
+			files, err := os.ReadDir(filepath.Join(s.dir, prefix, dir.Name()))
+			if err != nil {
+				return err
+			}
+			entries = append(entries, files...)
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+		last := ""
+		for _, file := range entries {
+			name := file.Name()
+			// Чанк, перенесенный во время обхода, может встретиться дважды
+			if file.IsDir() || !isChunkID(name) || name <= after || name == last {
+				continue
+			}
+
+			info, err := file.Info()
+			if err != nil {
+				// Чанк удален или перенесен во время обхода
+				if os.IsNotExist(err) {
+					continue
+				}
+				return err
+			}
+
+			last = name
+			if err := fn(storage.ChunkEntry{ID: name, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isChunkID проверяет, что имя файла является хешем чанка
+func isChunkID(name string) bool {
+	return len(name) == 64 && isHexString(name)
+}
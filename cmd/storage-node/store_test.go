@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Gammanik/distributed-storage/internal/storage"
+	"github.com/Gammanik/distributed-storage/internal/utils"
+)
+
+// putChunk записывает чанк с содержимым data и возвращает его ID
+func putChunk(t *testing.T, s *chunkStore, data []byte) string {
+	t.Helper()
+
+	id := utils.CalculateSHA256(data)
+	file, err := s.create(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.commit(id, file, int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestChunkStoreWalk(t *testing.T) {
+	s, err := openChunkStore(t.TempDir(), syncNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for i := 0; i < 50; i++ {
+		ids = append(ids, putChunk(t, s, []byte(fmt.Sprintf("chunk %d", i))))
+	}
+	sort.Strings(ids)
+
+	tests := []struct {
+		name  string
+		after string
+	}{
+		{"from start", ""},
+		{"one character", "a"},
+		{"prefix", "7f"},
+		{"existing chunk", ids[20]},
+		{"past the end", "g"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := s.walk(tt.after, func(e storage.ChunkEntry) error {
+				got = append(got, e.ID)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want []string
+			for _, id := range ids {
+				if id > tt.after {
+					want = append(want, id)
+				}
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("walk(%q) = %d chunks, want %d", tt.after, len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestOpenChunkStoreRemovesTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openChunkStore(dir, syncFileDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := putChunk(t, s, []byte("committed"))
+
+	// Записи, прерванные сбоем до переименования
+	leftovers := []string{
+		filepath.Join(filepath.Dir(s.path(id)), id+".123456.tmp"),
+		filepath.Join(dir, strings.Repeat("0", 64)+".42.tmp"),
+	}
+	for _, p := range leftovers {
+		if err := os.WriteFile(p, []byte("partial data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err = openChunkStore(dir, syncFileDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range leftovers {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed: %v", filepath.Base(p), err)
+		}
+	}
+	if count, size := s.stats(); count != 1 || size != int64(len("committed")) {
+		t.Errorf("stats() = %d chunks, %d bytes, want 1 chunk, %d bytes", count, size, len("committed"))
+	}
+	if !s.has(id) {
+		t.Errorf("committed chunk is missing after restart")
+	}
+}
+
+func TestOpenChunkStoreSyncMode(t *testing.T) {
+	for _, mode := range []string{syncNone, syncFile, syncFileDir} {
+		if _, err := openChunkStore(t.TempDir(), mode); err != nil {
+			t.Errorf("openChunkStore(%q): %v", mode, err)
+		}
+	}
+	for _, mode := range []string{"", "fsync+dir", "FSYNC"} {
+		if _, err := openChunkStore(t.TempDir(), mode); err == nil {
+			t.Errorf("openChunkStore(%q) accepted an unknown sync mode", mode)
+		}
+	}
+}
+
+func TestChunkStoreCommitExisting(t *testing.T) {
+	s, err := openChunkStore(t.TempDir(), syncFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("duplicate")
+	id := putChunk(t, s, data)
+
+	// Вторая запись того же чанка, например при параллельной загрузке
+	file, err := s.create(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.commit(id, file, int64(len(data))); !errors.Is(err, errChunkExists) {
+		t.Fatalf("commit() = %v, want %v", err, errChunkExists)
+	}
+
+	if _, err := os.Stat(file.Name()); !os.IsNotExist(err) {
+		t.Errorf("temporary file was not removed: %v", err)
+	}
+	if count, size := s.stats(); count != 1 || size != int64(len(data)) {
+		t.Errorf("stats() = %d chunks, %d bytes, want 1 chunk, %d bytes", count, size, len(data))
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Gammanik/distributed-storage/internal/gc"
+	"github.com/Gammanik/distributed-storage/internal/janitor"
+	"github.com/Gammanik/distributed-storage/internal/rebalance"
+	"github.com/Gammanik/distributed-storage/internal/reconcile"
+	"github.com/Gammanik/distributed-storage/internal/repair"
+)
+
+// AdminHandler обрабатывает административные запросы
+type AdminHandler struct {
+	Repairer   *repair.Repairer
+	Collector  *gc.Collector
+	Janitor    *janitor.Janitor
+	Rebalancer *rebalance.Rebalancer
+	Reconciler *reconcile.Reconciler
+}
+
+// Repair возвращает состояние фонового восстановления чанков.
+// POST запускает внеочередной проход.
+func (h *AdminHandler) Repair(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		h.Repairer.Trigger()
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Repairer.Status())
+}
+
+// GC возвращает состояние сборщика мусора.
+// POST запускает внеочередной проход.
+func (h *AdminHandler) GC(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		h.Collector.Trigger()
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Collector.Status())
+}
+
+// Reconcile возвращает состояние сверки серверов с метаданными и отчет
+// последнего прохода. POST запускает внеочередной проход.
+func (h *AdminHandler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		h.Reconciler.Trigger()
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Reconciler.Status())
+}
+
+// PendingUploads возвращает список незавершенных загрузок
+func (h *AdminHandler) PendingUploads(w http.ResponseWriter, r *http.Request) {
+	pending, err := h.Janitor.Pending()
+	if err != nil {
+		http.Error(w, "failed to list uploads", http.StatusInternalServerError)
+		log.Printf("Failed to list pending uploads: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pending)
+}
+
+// Rebalance возвращает состояние перебалансировки.
+// POST запускает проход (или продолжает прерванный), POST с dry-run=true
+// возвращает план перемещений без их выполнения, DELETE останавливает проход.
+func (h *AdminHandler) Rebalance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry-run")); dryRun {
+			limit := 100
+			if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v >= 0 {
+				limit = v
+			}
+			plan, err := h.Rebalancer.Plan(limit)
+			if err != nil {
+				http.Error(w, "failed to plan rebalance", http.StatusInternalServerError)
+				log.Printf("Failed to plan rebalance: %v", err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(plan)
+			return
+		}
+		if err := h.Rebalancer.Start(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodDelete:
+		h.Rebalancer.Stop()
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Rebalancer.Status())
+}
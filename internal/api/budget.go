@@ -0,0 +1,57 @@
+package api
+
+import "sync"
+
+// memoryBudget ограничивает суммарный объем чанков, которые одновременно
+// держат в памяти все запросы одного типа
+type memoryBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64 // 0 - без ограничения
+	used  int64
+}
+
+// newMemoryBudget создает бюджет с лимитом limit байт
+func newMemoryBudget(limit int64) *memoryBudget {
+	b := &memoryBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// tryAcquire резервирует n байт, если это не превышает лимит
+func (b *memoryBudget) tryAcquire(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit > 0 && b.used+n > b.limit {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// acquire резервирует n байт без учета лимита. Используется для чанка,
+// который нужен прямо сейчас: без него запрос не может продолжиться.
+func (b *memoryBudget) acquire(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used += n
+}
+
+// wait резервирует n байт, дожидаясь освобождения памяти. Чанк больше
+// всего лимита пропускается, когда остальная память свободна.
+func (b *memoryBudget) wait(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.limit > 0 && b.used > 0 && b.used+n > b.limit {
+		b.cond.Wait()
+	}
+	b.used += n
+}
+
+// release освобождает n байт
+func (b *memoryBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	b.cond.Broadcast()
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/Gammanik/distributed-storage/internal/cluster"
+	"github.com/Gammanik/distributed-storage/internal/nodestats"
+	"github.com/Gammanik/distributed-storage/internal/rebalance"
+	"github.com/Gammanik/distributed-storage/internal/repair"
+)
+
+// ClusterHandler обрабатывает запросы о составе кластера
+type ClusterHandler struct {
+	Nodes      *cluster.Registry
+	Tracker    *nodestats.Tracker
+	Rebalancer *rebalance.Rebalancer
+	Repairer   *repair.Repairer
+}
+
+// nodeView состояние сервера вместе со статистикой чтений
+type nodeView struct {
+	cluster.Node
+	Reads *nodestats.NodeStats `json:"reads,omitempty"`
+}
+
+// ListNodes возвращает текущее состояние всех серверов хранения
+func (h *ClusterHandler) ListNodes(w http.ResponseWriter, r *http.Request) {
+	stats := h.Tracker.Snapshot()
+
+	nodes := h.Nodes.Nodes()
+	result := make([]nodeView, 0, len(nodes))
+	for _, n := range nodes {
+		view := nodeView{Node: n}
+		if s, ok := stats[n.URL]; ok {
+			view.Reads = &s
+		}
+		result = append(result, view)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// AddNode добавляет сервер хранения. Тело запроса: {"url": "http://host:port"}
+func (h *ClusterHandler) AddNode(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		http.Error(w, "missing node url", http.StatusBadRequest)
+		return
+	}
+
+	added, err := h.Nodes.Register(req.URL, cluster.SourceAdmin)
+	if err != nil {
+		log.Printf("Failed to save node %s: %v", req.URL, err)
+	}
+	if !added {
+		http.Error(w, "node already registered", http.StatusConflict)
+		return
+	}
+
+	node, _ := h.Nodes.Get(req.URL)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(node)
+}
+
+// Heartbeat принимает heartbeat сервера хранения и регистрирует незнакомый
+// сервер. Тело запроса: {"url": "http://host:port", "status": {...}}
+func (h *ClusterHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string             `json:"url"`
+		Status cluster.NodeStatus `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		http.Error(w, "invalid heartbeat", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Nodes.Heartbeat(req.URL, req.Status); err != nil {
+		log.Printf("Failed to register node %s: %v", req.URL, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveNode удаляет сервер хранения по ID или URL. Новые чанки на него
+// больше не размещаются, уже записанные копии остаются в метаданных.
+func (h *ClusterHandler) RemoveNode(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.Nodes.Remove(r.PathValue("id")); err != nil {
+		if errors.Is(err, cluster.ErrNodeNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "remove failed", http.StatusInternalServerError)
+		log.Printf("Failed to remove node: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DrainNode выводит сервер из эксплуатации: исключает его из размещения
+// и запускает перенос его данных. Когда от сервера ничего не зависит,
+// он помечается как готовый к удалению.
+func (h *ClusterHandler) DrainNode(w http.ResponseWriter, r *http.Request) {
+	node, err := h.Nodes.SetDrain(r.PathValue("id"), cluster.DrainActive)
+	if err != nil {
+		if errors.Is(err, cluster.ErrNodeNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to save drain state of node %s: %v", node.URL, err)
+	}
+
+	status, err := h.Rebalancer.Drain(node.URL)
+	code := http.StatusAccepted
+	if err != nil {
+		code = http.StatusConflict
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// DrainProgress возвращает ход вывода сервера из эксплуатации
+func (h *ClusterHandler) DrainProgress(w http.ResponseWriter, r *http.Request) {
+	node, err := h.Nodes.Get(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	status, ok := h.Rebalancer.DrainStatus(node.URL)
+	if !ok {
+		http.Error(w, "node is not draining", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// ReportCorrupt принимает от сервера хранения список чанков, которые не прошли
+// проверку целостности, и ставит их в очередь на восстановление.
+// Тело запроса: {"url": "http://host:port", "chunks": ["<hash>", ...]}
+func (h *ClusterHandler) ReportCorrupt(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string   `json:"url"`
+		Chunks []string `json:"chunks"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Chunks) == 0 {
+		http.Error(w, "invalid corruption report", http.StatusBadRequest)
+		return
+	}
+
+	for _, id := range req.Chunks {
+		log.Printf("Storage node %s reported corrupt chunk %s", req.URL, id)
+	}
+	h.Repairer.Enqueue(req.Chunks...)
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/Gammanik/distributed-storage/internal/erasure"
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/utils"
+)
+
+// uploadShards кодирует чанк кодом Рида-Соломона и загружает каждый шард
+// на отдельный сервер хранения
+func (h *FileHandler) uploadShards(hash string, chunk []byte, policy metastore.DurabilityPolicy) (metastore.ChunkInfo, error) {
+	enc, err := erasure.New(policy.DataShards, policy.ParityShards)
+	if err != nil {
+		return metastore.ChunkInfo{}, err
+	}
+
+	shards := enc.Split(chunk)
+	if err := enc.Encode(shards); err != nil {
+		return metastore.ChunkInfo{}, err
+	}
+
+	nodes := h.rank(hash)
+	if len(nodes) < len(shards) {
+		return metastore.ChunkInfo{}, fmt.Errorf("not enough storage nodes for %d shards", len(shards))
+	}
+
+	ci := metastore.ChunkInfo{ChunkID: hash, Size: int64(len(chunk)), DataShards: policy.DataShards}
+	for i, shard := range shards {
+		// Хеш шарда с заголовком уникален для чанка, даже если содержимое
+		// шарда совпадает с шардом другого чанка
+		shard = erasure.WrapShard(hash, i, shard)
+		shardID := utils.CalculateSHA256(shard)
+		if err := h.Storage.UploadChunk(shardID, nodes[i], shard); err != nil {
+			return metastore.ChunkInfo{}, fmt.Errorf("shard %d to %s: %w", i, nodes[i], err)
+		}
+		ci.Shards = append(ci.Shards, metastore.ShardInfo{Index: i, ShardID: shardID, NodeURL: nodes[i]})
+	}
+	h.checkSpread(hash, nodes[:len(shards)], policy.ParityShards)
+
+	return ci, nil
+}
+
+// readShards скачивает любые DataShards шардов чанка и восстанавливает его данные
+func (h *FileHandler) readShards(ci metastore.ChunkInfo) ([]byte, error) {
+	dataShards := ci.DataShards
+	enc, err := erasure.New(dataShards, len(ci.Shards)-dataShards)
+	if err != nil {
+		return nil, err
+	}
+
+	// Шарды запрашиваются начиная с самых быстрых серверов
+	nodes := make([]string, len(ci.Shards))
+	for i, si := range ci.Shards {
+		nodes[i] = si.NodeURL
+	}
+
+	shards := make([][]byte, len(ci.Shards))
+	have := 0
+	for _, j := range h.nodeOrder(nodes) {
+		if have == dataShards {
+			break
+		}
+		si := ci.Shards[j]
+
+		done := h.Tracker.Start(si.NodeURL)
+		data, err := h.Storage.DownloadChunk(context.Background(), si.ShardID, si.NodeURL)
+		done(int64(len(data)), err)
+		if err != nil {
+			log.Printf("Failed to download shard %d from %s: %v", si.Index, si.NodeURL, err)
+			continue
+		}
+		if hash := utils.CalculateSHA256(data); hash != si.ShardID {
+			log.Printf("Warning: shard hash mismatch. Expected: %s, Got: %s", si.ShardID, hash)
+			continue
+		}
+		if data, err = erasure.UnwrapShard(ci.ChunkID, si.Index, data); err != nil {
+			log.Printf("Warning: shard %d of chunk %s on %s: %v", si.Index, ci.ChunkID, si.NodeURL, err)
+			continue
+		}
+
+		shards[si.Index] = data
+		have++
+	}
+
+	if err := enc.Reconstruct(shards); err != nil {
+		return nil, err
+	}
+
+	data, err := enc.Join(shards, int(ci.Size))
+	if err != nil {
+		return nil, err
+	}
+
+	if hash := utils.CalculateSHA256(data); hash != ci.ChunkID {
+		return nil, fmt.Errorf("reconstructed chunk hash mismatch. Expected: %s, Got: %s", ci.ChunkID, hash)
+	}
+
+	return data, nil
+}
@@ -2,14 +2,19 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/Gammanik/distributed-storage/internal/chunker"
+	"github.com/Gammanik/distributed-storage/internal/cluster"
 	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/nodestats"
+	"github.com/Gammanik/distributed-storage/internal/placement"
 	"github.com/Gammanik/distributed-storage/internal/storage"
-	"github.com/Gammanik/distributed-storage/internal/utils"
-	"io"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
+	"sync"
 
 	"github.com/google/uuid"
 )
@@ -18,8 +23,29 @@ import (
 type FileHandler struct {
 	Store       metastore.MetaStore
 	Storage     storage.Client
-	StoragePool []string
+	StoragePool *cluster.Registry   // Реестр серверов хранения
+	Placement   placement.Placement // Выбор серверов для чанка (по умолчанию rendezvous hashing)
+	DomainLevel string              // Уровень failure domain, по которому разносятся копии
 	ChunkSize   int64
+	Chunking    string // Алгоритм разбиения на чанки по умолчанию
+	CDCMinSize  int64
+	CDCAvgSize  int64
+	CDCMaxSize  int64
+	Durability  metastore.DurabilityPolicy // Политика надежности по умолчанию
+	OnDelete    func()                     // Вызывается после удаления файла
+
+	PrefetchDepth  int   // Количество чанков, скачиваемых заранее при отдаче файла
+	PrefetchMemory int64 // Общий лимит памяти под скачанные заранее чанки
+	UploadWorkers  int   // Количество параллельных загрузок чанков одного файла
+	UploadMemory   int64 // Общий лимит памяти под чанки, ожидающие загрузки
+
+	Tracker    *nodestats.Tracker // Статистика задержек серверов хранения
+	HedgeReads bool               // Дублировать медленные запросы чтения на другую реплику
+
+	prefetchOnce sync.Once
+	prefetchMem  *memoryBudget
+	uploadOnce   sync.Once
+	uploadMem    *memoryBudget
 }
 
 // Upload обрабатывает загрузку файла
@@ -41,76 +67,210 @@ func (h *FileHandler) Upload(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Получаем алгоритм разбиения из заголовка или используем значение по умолчанию
+	mode := h.Chunking
+	if v := r.Header.Get("X-Chunking"); v != "" {
+		mode = v
+	}
+	if mode == "" {
+		mode = chunker.ModeFixed
+	}
+
+	// Создаем reader для чтения чанков
+	chunkReader, err := chunker.New(r.Body, chunker.Config{
+		Mode:      mode,
+		ChunkSize: chunkSize,
+		MinSize:   h.CDCMinSize,
+		AvgSize:   h.CDCAvgSize,
+		MaxSize:   h.CDCMaxSize,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer chunkReader.Close()
+
+	// Получаем политику надежности из заголовков или используем значение по умолчанию
+	policy, err := h.durabilityFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Инициализируем запись о файле в метаданных
-	if err := h.Store.InitFile(fileID, filename, 0); err != nil {
+	if err := h.Store.InitFile(fileID, filename, 0, mode, policy); err != nil {
 		http.Error(w, "failed to init file", http.StatusInternalServerError)
 		log.Printf("Failed to init file: %v", err)
 		return
 	}
 
-	// Создаем reader для чтения чанков
-	chunkReader := chunker.NewChunkReader(r.Body, chunkSize)
-	index := 0
-
-	// Читаем и загружаем чанки
-	for {
-		chunk, hash, err := chunkReader.NextChunk()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
+	// Читаем и загружаем чанки параллельно
+	if err := h.uploadPipeline(fileID, chunkReader, policy); err != nil {
+		var readErr *chunkReadError
+		if errors.As(err, &readErr) {
 			http.Error(w, "reading failed", http.StatusInternalServerError)
-			log.Printf("Failed to read chunk: %v", err)
-			return
+		} else {
+			http.Error(w, "upload failed: "+err.Error(), http.StatusBadGateway)
 		}
+		log.Printf("Failed to upload file %s: %v", fileID, err)
+		h.abortUpload(fileID)
+		return
+	}
 
-		// Проверяем, существует ли уже чанк с таким хешем
-		if found, ci, _ := h.Store.HasChunkByHash(hash); found {
-			// Чанк уже существует, просто сохраняем его ссылку
-			h.Store.SaveChunk(fileID, index, ci)
-			index++
-			continue
-		}
+	// Помечаем файл как полностью загруженный
+	if err := h.Store.MarkComplete(fileID); err != nil {
+		http.Error(w, "failed to complete file", http.StatusInternalServerError)
+		log.Printf("Failed to mark file %s complete: %v", fileID, err)
+		return
+	}
+
+	// Отвечаем клиенту идентификатором файла
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, fileID)
+}
+
+// chunkPlacement размещение чанка на серверах хранения
+type chunkPlacement struct {
+	hash     string
+	replicas []metastore.ChunkInfo
+	fresh    bool          // Чанк загружен впервые, а не найден по хешу
+	late     *lateReplicas // Копии, которые дописываются после кворума
+}
 
-		// Выбираем серверы для хранения чанка
-		nodes := utils.ChooseStorageNodes(index, h.StoragePool, 2)
-		primaryNode := nodes[0]
+// storeChunk сохраняет чанк на серверах хранения согласно политике надежности
+// и записывает его размещение в метаданные файла
+func (h *FileHandler) storeChunk(fileID string, index int, hash string, chunk []byte, policy metastore.DurabilityPolicy) error {
+	// Сборщик мусора не удалит чанк, пока ссылка на него не записана
+	h.Store.ReserveChunk(hash)
+	defer h.Store.ReleaseChunk(hash)
 
-		// Загружаем чанк на основной сервер
-		if err := h.Storage.UploadChunk(hash, primaryNode, chunk); err != nil {
-			http.Error(w, "upload failed", http.StatusBadGateway)
-			log.Printf("Failed to upload chunk to primary node: %v", err)
-			return
+	p, err := h.placeChunk(hash, chunk, policy)
+	if err != nil {
+		return err
+	}
+	return h.recordChunk(fileID, index, p)
+}
+
+// placeChunk загружает чанк на серверы хранения или находит уже сохраненную копию.
+// Вызывающий должен отметить чанк через ReserveChunk до вызова и снять отметку
+// только после recordChunk.
+func (h *FileHandler) placeChunk(hash string, chunk []byte, policy metastore.DurabilityPolicy) (chunkPlacement, error) {
+	// Проверяем, существует ли уже чанк с таким хешем
+	if found, ci, _ := h.Store.HasChunkByHash(hash); found {
+		// Чанк уже существует, просто сохраняем ссылку на все его копии.
+		// Старые записи не содержат размер чанка.
+		ci.Size = int64(len(chunk))
+		if replicas := h.knownReplicas(ci); len(replicas) > 0 {
+			return chunkPlacement{hash: hash, replicas: replicas}, nil
 		}
+		// Ни одной копии не осталось: записываем чанк заново
+	}
 
-		// Создаем информацию о чанке
-		ci := metastore.ChunkInfo{ChunkID: hash, NodeURL: primaryNode}
+	// Чанк с erasure coding разбивается на шарды, каждый на своем сервере
+	if policy.Mode == metastore.DurabilityErasure {
+		ci, err := h.uploadShards(hash, chunk, policy)
+		if err != nil {
+			return chunkPlacement{}, err
+		}
+		return chunkPlacement{hash: hash, replicas: []metastore.ChunkInfo{ci}, fresh: true}, nil
+	}
 
-		// Сохраняем информацию о чанке
-		h.Store.SaveChunk(fileID, index, ci)
-		h.Store.SaveChunkHash(hash, ci)
+	// Загружаем копии чанка и дожидаемся кворума записи
+	replicas, late, err := h.uploadReplicas(hash, chunk, policy)
+	if err != nil {
+		return chunkPlacement{}, err
+	}
+	return chunkPlacement{hash: hash, replicas: replicas, late: late, fresh: true}, nil
+}
 
-		// Загружаем чанк на резервные серверы (best effort)
-		for i := 1; i < len(nodes); i++ {
-			if err := h.Storage.UploadChunk(hash, nodes[i], chunk); err != nil {
-				log.Printf("Warning: failed to upload replica to %s: %v", nodes[i], err)
-				continue
-			}
+// knownReplicas возвращает копии уже сохраненного чанка: запись для
+// дедупликации и все известные размещения, кроме копий, которых точно нет
+// на серверах. Копии на недоступных серверах сохраняются, их проверит
+// восстановление.
+func (h *FileHandler) knownReplicas(ci metastore.ChunkInfo) []metastore.ChunkInfo {
+	if len(ci.Shards) > 0 {
+		return []metastore.ChunkInfo{ci}
+	}
 
-			// Сохраняем информацию о реплике
-			replica := metastore.ChunkInfo{ChunkID: hash, NodeURL: nodes[i]}
-			h.Store.SaveChunk(fileID, index, replica)
+	candidates := []string{ci.NodeURL}
+	locations, err := h.Store.ChunkLocations(ci.ChunkID)
+	if err != nil {
+		log.Printf("Failed to read locations of chunk %s: %v", ci.ChunkID, err)
+	}
+	for _, loc := range locations {
+		if loc.NodeURL != "" && len(loc.Shards) == 0 && !slices.Contains(candidates, loc.NodeURL) {
+			candidates = append(candidates, loc.NodeURL)
 		}
+	}
 
-		index++
+	var replicas []metastore.ChunkInfo
+	for _, node := range candidates {
+		if ok, err := h.Storage.HasChunk(ci.ChunkID, node); err == nil && !ok {
+			continue
+		}
+		replicas = append(replicas, metastore.ChunkInfo{ChunkID: ci.ChunkID, NodeURL: node, Size: ci.Size})
 	}
+	return replicas
+}
 
-	// Помечаем файл как полностью загруженный
-	h.Store.MarkComplete(fileID)
+// recordChunk записывает размещение чанка в метаданные файла
+func (h *FileHandler) recordChunk(fileID string, index int, p chunkPlacement) error {
+	// Сохраняем информацию о всех записанных копиях
+	for _, ci := range p.replicas {
+		if err := h.Store.SaveChunk(fileID, index, ci); err != nil {
+			return err
+		}
+	}
+	if p.fresh {
+		if err := h.Store.SaveChunkHash(p.hash, p.replicas[0]); err != nil {
+			return err
+		}
+	}
+	if p.late != nil {
+		go h.recordLate(fileID, index, p)
+	}
+	return nil
+}
 
-	// Отвечаем клиенту идентификатором файла
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintln(w, fileID)
+// abortUpload удаляет метаданные незавершенной загрузки и освобождает ее чанки
+func (h *FileHandler) abortUpload(fileID string) {
+	if err := h.Store.DeleteFile(fileID); err != nil {
+		log.Printf("Failed to abort upload %s: %v", fileID, err)
+		return
+	}
+	if h.OnDelete != nil {
+		h.OnDelete()
+	}
+}
+
+// pool возвращает серверы хранения, доступные для размещения чанков
+func (h *FileHandler) pool() []string {
+	return h.StoragePool.Available()
+}
+
+// rank возвращает доступные серверы в порядке предпочтения для чанка
+func (h *FileHandler) rank(hash string) []string {
+	p := h.Placement
+	if p == nil {
+		p = placement.Rendezvous{}
+	}
+	return p.Rank(hash, h.pool())
+}
+
+// prefetchBudget возвращает общий для всех скачиваний бюджет памяти
+func (h *FileHandler) prefetchBudget() *memoryBudget {
+	h.prefetchOnce.Do(func() {
+		h.prefetchMem = newMemoryBudget(h.PrefetchMemory)
+	})
+	return h.prefetchMem
+}
+
+// uploadBudget возвращает общий для всех загрузок бюджет памяти
+func (h *FileHandler) uploadBudget() *memoryBudget {
+	h.uploadOnce.Do(func() {
+		h.uploadMem = newMemoryBudget(h.UploadMemory)
+	})
+	return h.uploadMem
 }
 
 // Download обрабатывает скачивание файла
@@ -140,42 +300,60 @@ func (h *FileHandler) Download(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Disposition", "attachment; filename=\""+meta.Filename+"\"")
 	w.Header().Set("Content-Type", "application/octet-stream")
 
+	// Если смещения частей известны, отдаем файл с поддержкой Range и If-Range
+	if len(meta.ChunkOffsets) == meta.TotalChunks {
+		etag := `"` + meta.FileID + `"`
+		w.Header().Set("ETag", etag)
+		fr := newFileReader(h, meta, rangeEnd(r, etag, meta.Size))
+		defer fr.Close()
+		dw := &deferredWriter{ResponseWriter: w}
+		http.ServeContent(dw, r, meta.Filename, meta.UpdatedAt, fr)
+		if !dw.written && fr.err != nil {
+			// Первая часть диапазона недоступна, ответ еще не начат
+			for _, key := range []string{"Content-Length", "Content-Range", "Content-Disposition", "ETag"} {
+				w.Header().Del(key)
+			}
+			http.Error(w, "missing chunk", http.StatusInternalServerError)
+			log.Printf("File %s is unavailable: %v", fileID, fr.err)
+			return
+		}
+		dw.flush()
+		return
+	}
+
 	// Скачиваем и отправляем каждый чанк
 	for i := 0; i < meta.TotalChunks; i++ {
-		replicas := meta.Chunks[i]
-		var success bool
+		data, err := h.readChunk(meta.Chunks[i])
+		if err != nil {
+			http.Error(w, "missing chunk", http.StatusInternalServerError)
+			log.Printf("Chunk %d of file %s is unavailable: %v", i, fileID, err)
+			return
+		}
+
+		// Отправляем чанк клиенту
+		if _, err := w.Write(data); err != nil {
+			log.Printf("Failed to write chunk to response: %v", err)
+			http.Error(w, "download failed", http.StatusInternalServerError)
+			return
+		}
+	}
+}
 
-		// Пробуем скачать чанк с одного из доступных серверов
+// readChunk скачивает чанк с одного из доступных серверов и проверяет его целостность
+func (h *FileHandler) readChunk(replicas []metastore.ChunkInfo) ([]byte, error) {
+	// Чанк с erasure coding собирается из шардов
+	if len(replicas) > 0 && len(replicas[0].Shards) > 0 {
 		for _, replica := range replicas {
-			data, err := h.Storage.DownloadChunk(replica.ChunkID, replica.NodeURL)
+			data, err := h.readShards(replica)
 			if err == nil {
-				// Проверяем целостность данных
-				hash := utils.CalculateSHA256(data)
-				if hash != replica.ChunkID {
-					log.Printf("Warning: chunk hash mismatch. Expected: %s, Got: %s", replica.ChunkID, hash)
-					continue
-				}
-
-				// Отправляем чанк клиенту
-				if _, err := w.Write(data); err != nil {
-					log.Printf("Failed to write chunk to response: %v", err)
-					http.Error(w, "download failed", http.StatusInternalServerError)
-					return
-				}
-
-				success = true
-				break
+				return data, nil
 			}
-
-			log.Printf("Failed to download chunk from %s: %v", replica.NodeURL, err)
-		}
-
-		if !success {
-			http.Error(w, "missing chunk", http.StatusInternalServerError)
-			log.Printf("All replicas for chunk %d of file %s are unavailable", i, fileID)
-			return
+			log.Printf("Failed to reconstruct chunk %s: %v", replica.ChunkID, err)
 		}
+		return nil, fmt.Errorf("all replicas are unavailable")
 	}
+
+	return h.readReplicas(replicas)
 }
 
 // GetFileInfo возвращает информацию о файле
@@ -196,7 +374,36 @@ func (h *FileHandler) GetFileInfo(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"fileID":      meta.FileID,
 		"filename":    meta.Filename,
+		"chunking":    meta.Chunking,
+		"durability":  meta.Durability,
 		"totalChunks": meta.TotalChunks,
+		"size":        meta.Size,
 		"complete":    meta.Complete,
 	})
 }
+
+// Delete удаляет файл. Чанки, на которые больше не ссылается ни один файл,
+// удаляются с серверов хранения сборщиком мусора.
+func (h *FileHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	fileID := r.PathValue("id")
+	if fileID == "" {
+		http.Error(w, "missing fileID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Store.DeleteFile(fileID); err != nil {
+		if errors.Is(err, metastore.ErrFileNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "delete failed", http.StatusInternalServerError)
+		log.Printf("Failed to delete file %s: %v", fileID, err)
+		return
+	}
+
+	if h.OnDelete != nil {
+		h.OnDelete()
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
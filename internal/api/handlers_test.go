@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/cluster"
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/nodestats"
+	"github.com/Gammanik/distributed-storage/internal/storage"
+	"github.com/Gammanik/distributed-storage/internal/utils"
+)
+
+// fakeStorage хранит чанки в памяти и запоминает запросы к серверам
+type fakeStorage struct {
+	mu        sync.Mutex
+	chunks    map[string][]byte        // nodeURL + "/" + chunkID -> данные
+	delay     map[string]time.Duration // Задержка ответа сервера
+	down      map[string]bool          // Недоступные серверы
+	calls     []string                 // Запросы скачивания по порядку
+	cancelled int                      // Скачивания, прерванные отменой
+
+	onUpload func(chunkID string, data []byte) // Вызывается перед записью чанка
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{
+		chunks: make(map[string][]byte),
+		delay:  make(map[string]time.Duration),
+		down:   make(map[string]bool),
+	}
+}
+
+func (s *fakeStorage) record(format string, args ...interface{}) {
+	s.calls = append(s.calls, fmt.Sprintf(format, args...))
+}
+
+// setDelay задает задержку ответов сервера
+func (s *fakeStorage) setDelay(nodeURL string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay[nodeURL] = d
+}
+
+// setDown делает сервер недоступным или снова доступным
+func (s *fakeStorage) setDown(nodeURL string, down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down[nodeURL] = down
+}
+
+// downloads возвращает запросы скачивания и очищает список
+func (s *fakeStorage) downloads() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls := s.calls
+	s.calls = nil
+	return calls
+}
+
+func (s *fakeStorage) UploadChunk(chunkID, nodeURL string, data []byte) error {
+	s.mu.Lock()
+	delay := s.delay[nodeURL]
+	s.mu.Unlock()
+	time.Sleep(delay)
+	if s.onUpload != nil {
+		s.onUpload(chunkID, data)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down[nodeURL] {
+		return fmt.Errorf("node %s is down", nodeURL)
+	}
+	s.chunks[nodeURL+"/"+chunkID] = bytes.Clone(data)
+	return nil
+}
+
+func (s *fakeStorage) DownloadChunk(ctx context.Context, chunkID, nodeURL string) ([]byte, error) {
+	s.mu.Lock()
+	s.record("get %s", chunkID)
+	delay := s.delay[nodeURL]
+	s.mu.Unlock()
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		s.mu.Lock()
+		s.cancelled++
+		s.mu.Unlock()
+		return nil, ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.chunks[nodeURL+"/"+chunkID]
+	if !ok || s.down[nodeURL] {
+		return nil, fmt.Errorf("chunk %s not found on %s", chunkID, nodeURL)
+	}
+	return bytes.Clone(data), nil
+}
+
+func (s *fakeStorage) DownloadChunkRange(chunkID, nodeURL string, off, length int64) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("range %s %d %d", chunkID, off, length)
+	data, ok := s.chunks[nodeURL+"/"+chunkID]
+	if !ok {
+		return nil, fmt.Errorf("chunk %s not found on %s", chunkID, nodeURL)
+	}
+	data = data[off:]
+	if length >= 0 {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(bytes.Clone(data))), nil
+}
+
+func (s *fakeStorage) HasChunk(chunkID, nodeURL string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.chunks[nodeURL+"/"+chunkID]
+	return ok, nil
+}
+
+func (s *fakeStorage) DeleteChunk(chunkID, nodeURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.chunks, nodeURL+"/"+chunkID)
+	return nil
+}
+
+func (s *fakeStorage) ListChunks(nodeURL, after string, limit int) ([]storage.ChunkEntry, string, error) {
+	return nil, "", nil
+}
+
+func (s *fakeStorage) StreamChunks(nodeURL, after string, fn func(e storage.ChunkEntry) error) error {
+	return nil
+}
+
+// newTestHandler создает FileHandler с хранилищем метаданных во временном
+// каталоге и серверами хранения в памяти
+func newTestHandler(t *testing.T, nodes ...string) (*FileHandler, *fakeStorage) {
+	t.Helper()
+	store, err := metastore.NewBoltStore(filepath.Join(t.TempDir(), "meta.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	fs := newFakeStorage()
+	return &FileHandler{
+		Store:          store,
+		Storage:        fs,
+		StoragePool:    cluster.New(nodes),
+		ChunkSize:      100,
+		PrefetchDepth:  2,
+		PrefetchMemory: 1 << 20,
+		Tracker:        nodestats.NewTracker(),
+	}, fs
+}
+
+// putFile записывает файл из чанков размером chunkSize на сервер node и
+// возвращает хеши чанков
+func putFile(t *testing.T, h *FileHandler, fs *fakeStorage, fileID, node string, data []byte, chunkSize int) []string {
+	t.Helper()
+	policy := metastore.DurabilityPolicy{Mode: metastore.DurabilityReplication, Replicas: 1, MinReplicas: 1}
+	if err := h.Store.InitFile(fileID, "test.bin", int64(len(data)), "fixed", policy); err != nil {
+		t.Fatal(err)
+	}
+
+	var hashes []string
+	for i := 0; i*chunkSize < len(data); i++ {
+		chunk := data[i*chunkSize : min((i+1)*chunkSize, len(data))]
+		hash := utils.CalculateSHA256(chunk)
+		fs.UploadChunk(hash, node, chunk)
+		info := metastore.ChunkInfo{ChunkID: hash, NodeURL: node, Size: int64(len(chunk))}
+		if err := h.Store.SaveChunk(fileID, i, info); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := h.Store.MarkComplete(fileID); err != nil {
+		t.Fatal(err)
+	}
+	return hashes
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/utils"
+)
+
+// attempt результат скачивания чанка с одной реплики
+type attempt struct {
+	replica metastore.ChunkInfo
+	data    []byte
+	err     error
+}
+
+// nodeOrder возвращает индексы серверов по убыванию предпочтительности:
+// недоступные серверы идут последними, остальные упорядочены по задержке,
+// ошибкам и нагрузке
+func (h *FileHandler) nodeOrder(nodes []string) []int {
+	down := make([]bool, len(nodes))
+	for i, node := range nodes {
+		down[i] = h.StoragePool.IsDown(node)
+	}
+
+	order := h.Tracker.Order(nodes)
+	sort.SliceStable(order, func(a, b int) bool { return !down[order[a]] && down[order[b]] })
+	return order
+}
+
+// rankReplicas возвращает реплики по убыванию предпочтительности с учетом
+// доступности, задержки, ошибок и нагрузки серверов
+func (h *FileHandler) rankReplicas(replicas []metastore.ChunkInfo) []metastore.ChunkInfo {
+	if len(replicas) < 2 {
+		return replicas
+	}
+
+	nodes := make([]string, len(replicas))
+	for i, replica := range replicas {
+		nodes[i] = replica.NodeURL
+	}
+
+	result := make([]metastore.ChunkInfo, len(replicas))
+	for i, j := range h.nodeOrder(nodes) {
+		result[i] = replicas[j]
+	}
+	return result
+}
+
+// readReplicas скачивает реплицированный чанк с самой быстрой реплики.
+// Если она не ответила за 95-й перцентиль своей задержки, параллельно
+// запрашивается следующая реплика, и используется первый верный ответ.
+// При ошибке следующая реплика запрашивается сразу.
+//
+// Порядок реплик задает только rankReplicas. Запрос, идущий параллельно
+// с другими, держит в памяти еще один чанк и резервирует его размер в бюджете
+// предварительного скачивания; без свободного бюджета он не запускается.
+// После ответа остальные запросы отменяются, а резерв освобождается, когда
+// они завершатся.
+func (h *FileHandler) readReplicas(replicas []metastore.ChunkInfo) ([]byte, error) {
+	replicas = h.rankReplicas(replicas)
+	if len(replicas) == 0 {
+		return nil, errors.New("all replicas are unavailable")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	budget := h.prefetchBudget()
+	var reserved int64
+
+	// Буфер на все реплики: проигравшие запросы завершаются без ожидания
+	results := make(chan attempt, len(replicas))
+	launched, pending := 0, 0
+	launch := func() bool {
+		replica := replicas[launched]
+		if pending > 0 {
+			if !budget.tryAcquire(replica.Size) {
+				return false
+			}
+			reserved += replica.Size
+		}
+		launched++
+		pending++
+		go func() {
+			data, err := h.downloadReplica(ctx, replica)
+			results <- attempt{replica: replica, data: data, err: err}
+		}()
+		return true
+	}
+
+	// finish отменяет оставшиеся запросы и освобождает резерв после их завершения
+	finish := func() {
+		cancel()
+		go func(pending int, reserved int64) {
+			for ; pending > 0; pending-- {
+				<-results
+			}
+			budget.release(reserved)
+		}(pending, reserved)
+	}
+
+	var hedge <-chan time.Time
+	armHedge := func() {
+		hedge = nil
+		if !h.HedgeReads || launched == len(replicas) {
+			return
+		}
+		last := replicas[launched-1]
+		if delay := h.Tracker.HedgeDelay(last.NodeURL, last.Size); delay > 0 {
+			hedge = time.After(delay)
+		}
+	}
+
+	launch()
+	armHedge()
+
+	for pending > 0 {
+		select {
+		case a := <-results:
+			pending--
+			if a.err == nil {
+				finish()
+				return a.data, nil
+			}
+			log.Printf("Failed to download chunk from %s: %v", a.replica.NodeURL, a.err)
+			if launched < len(replicas) && launch() {
+				armHedge()
+			}
+
+		case <-hedge:
+			hedge = nil
+			if launch() {
+				armHedge()
+			}
+		}
+	}
+
+	finish()
+	return nil, errors.New("all replicas are unavailable")
+}
+
+// downloadReplica скачивает чанк с одного сервера, проверяет его целостность
+// и учитывает задержку в статистике сервера
+func (h *FileHandler) downloadReplica(ctx context.Context, replica metastore.ChunkInfo) ([]byte, error) {
+	done := h.Tracker.Start(replica.NodeURL)
+
+	data, err := h.Storage.DownloadChunk(ctx, replica.ChunkID, replica.NodeURL)
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	} else if err == nil {
+		if hash := utils.CalculateSHA256(data); hash != replica.ChunkID {
+			data = nil
+			err = fmt.Errorf("chunk hash mismatch. Expected: %s, Got: %s", replica.ChunkID, hash)
+		}
+	}
+
+	done(int64(len(data)), err)
+	return data, err
+}
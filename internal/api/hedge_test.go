@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/utils"
+)
+
+// hedgeSetup записывает чанк на два сервера и набирает статистику, чтобы
+// хеджирование включилось
+func hedgeSetup(t *testing.T, memory int64) (*FileHandler, *fakeStorage, []byte, []metastore.ChunkInfo) {
+	t.Helper()
+	slow, fast := "http://slow", "http://fast"
+	h, fs := newTestHandler(t, slow, fast)
+	h.HedgeReads = true
+	h.PrefetchMemory = memory
+
+	data := bytes.Repeat([]byte("chunk"), 20)
+	hash := utils.CalculateSHA256(data)
+	replicas := []metastore.ChunkInfo{
+		{ChunkID: hash, NodeURL: slow, Size: int64(len(data))},
+		{ChunkID: hash, NodeURL: fast, Size: int64(len(data))},
+	}
+	for _, r := range replicas {
+		fs.UploadChunk(hash, r.NodeURL, data)
+	}
+	for i := 0; i < 32; i++ {
+		for _, r := range replicas {
+			h.Tracker.Start(r.NodeURL)(int64(len(data)), nil)
+		}
+	}
+	fs.setDelay(slow, 500*time.Millisecond)
+	return h, fs, data, replicas
+}
+
+func TestReadReplicasCancelsHedgeLoser(t *testing.T) {
+	h, fs, data, replicas := hedgeSetup(t, 1<<20)
+
+	started := time.Now()
+	got, err := h.readReplicas(replicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("data mismatch")
+	}
+	if elapsed := time.Since(started); elapsed > 250*time.Millisecond {
+		t.Errorf("hedged read took %v", elapsed)
+	}
+
+	// Проигравший запрос отменяется, а его резерв возвращается в бюджет
+	deadline := time.Now().Add(time.Second)
+	for {
+		fs.mu.Lock()
+		cancelled := fs.cancelled
+		fs.mu.Unlock()
+		budget := h.prefetchBudget()
+		budget.mu.Lock()
+		used := budget.used
+		budget.mu.Unlock()
+
+		if cancelled == 1 && used == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cancelled = %d, budget used = %d; want 1 and 0", cancelled, used)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReadReplicasHedgeRespectsBudget(t *testing.T) {
+	// Бюджет меньше чанка: второй запрос параллельно не запускается
+	h, fs, data, replicas := hedgeSetup(t, 10)
+
+	got, err := h.readReplicas(replicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("data mismatch")
+	}
+	if calls := fs.downloads(); len(calls) != 1 {
+		t.Errorf("downloads = %q, want only the first replica", calls)
+	}
+}
+
+func TestReadReplicasFallsBackOnError(t *testing.T) {
+	h, fs, data, replicas := hedgeSetup(t, 1<<20)
+	fs.setDelay(replicas[0].NodeURL, 0)
+	fs.setDown(replicas[0].NodeURL, true)
+
+	got, err := h.readReplicas(replicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("data mismatch")
+	}
+
+	fs.setDown(replicas[1].NodeURL, true)
+	if _, err := h.readReplicas(replicas); err == nil {
+		t.Fatal("expected error with all replicas down")
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Gammanik/distributed-storage/internal/chunker"
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+)
+
+// chunkReadError ошибка чтения тела запроса при загрузке
+type chunkReadError struct {
+	err error
+}
+
+func (e *chunkReadError) Error() string { return "failed to read chunk: " + e.err.Error() }
+func (e *chunkReadError) Unwrap() error { return e.err }
+
+// uploadJob чанк, ожидающий загрузки на серверы хранения
+type uploadJob struct {
+	index int
+	hash  string
+	data  []byte
+}
+
+// uploadResult результат загрузки одного чанка
+type uploadResult struct {
+	index int
+	place chunkPlacement
+	err   error
+}
+
+// uploadPipeline читает чанки из cr и параллельно загружает их на серверы
+// хранения. Чтение следующих чанков продолжается, пока предыдущие загружаются;
+// объем прочитанных, но не загруженных данных ограничен общим бюджетом памяти.
+// Размещения записываются в метаданные строго по порядку индексов.
+func (h *FileHandler) uploadPipeline(fileID string, cr chunker.Chunker, policy metastore.DurabilityPolicy) error {
+	workers := h.UploadWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	budget := h.uploadBudget()
+
+	jobs := make(chan uploadJob, workers)
+	results := make(chan uploadResult, workers)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	cancel := func() { stopOnce.Do(func() { close(stop) }) }
+
+	// Загрузчики: передают чанки на серверы хранения и освобождают память
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				// Отметка снимается после записи размещения в метаданные
+				h.Store.ReserveChunk(job.hash)
+				p, err := h.placeChunk(job.hash, job.data, policy)
+				if p.late != nil {
+					// Данные чанка нужны фоновым загрузкам копий сверх кворума
+					go func(late *lateReplicas, n int64) {
+						<-late.done
+						budget.release(n)
+					}(p.late, int64(len(job.data)))
+				} else {
+					budget.release(int64(len(job.data)))
+				}
+				if err != nil {
+					h.Store.ReleaseChunk(job.hash)
+				}
+				results <- uploadResult{index: job.index, place: p, err: err}
+			}
+		}()
+	}
+
+	// Читатель: разбивает тело запроса на чанки и копирует их в собственные буферы,
+	// потому что буфер чанкера переиспользуется при следующем чтении
+	var readErr error
+	go func() {
+		defer close(jobs)
+		for index := 0; ; index++ {
+			chunk, hash, err := cr.NextChunk()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				readErr = &chunkReadError{err: err}
+				cancel()
+				return
+			}
+
+			budget.wait(int64(len(chunk)))
+			job := uploadJob{index: index, hash: hash, data: append([]byte(nil), chunk...)}
+
+			select {
+			case jobs <- job:
+			case <-stop:
+				budget.release(int64(len(job.data)))
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Запись в метаданные по порядку индексов
+	var firstErr error
+	pending := make(map[int]chunkPlacement)
+	next := 0
+	for res := range results {
+		if firstErr != nil {
+			if res.err == nil {
+				h.Store.ReleaseChunk(res.place.hash)
+			}
+			continue
+		}
+		if res.err != nil {
+			firstErr = fmt.Errorf("chunk %d: %w", res.index, res.err)
+			cancel()
+			continue
+		}
+
+		pending[res.index] = res.place
+		for {
+			p, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			err := h.recordChunk(fileID, next, p)
+			h.Store.ReleaseChunk(p.hash)
+			if err != nil {
+				firstErr = fmt.Errorf("chunk %d: %w", next, err)
+				cancel()
+				break
+			}
+			next++
+		}
+	}
+
+	// Размещения, которые уже не будут записаны
+	for _, p := range pending {
+		h.Store.ReleaseChunk(p.hash)
+	}
+
+	if readErr != nil {
+		return readErr
+	}
+	return firstErr
+}
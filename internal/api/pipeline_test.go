@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/chunker"
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/utils"
+)
+
+// orderStore запоминает порядок записи частей файла
+type orderStore struct {
+	metastore.MetaStore
+	mu      sync.Mutex
+	indexes []int
+}
+
+func (s *orderStore) SaveChunk(fileID string, index int, info metastore.ChunkInfo) error {
+	s.mu.Lock()
+	s.indexes = append(s.indexes, index)
+	s.mu.Unlock()
+	return s.MetaStore.SaveChunk(fileID, index, info)
+}
+
+// pipelineData возвращает данные из count чанков размером 100 байт,
+// у которых первый байт равен номеру чанка
+func pipelineData(count int) []byte {
+	data := make([]byte, count*100)
+	for i := range data {
+		data[i] = byte(i/100) + byte(i%100)*3
+	}
+	return data
+}
+
+func TestUploadPipelineRecordsInOrder(t *testing.T) {
+	h, fs := newTestHandler(t, "http://a", "http://b")
+	store := &orderStore{MetaStore: h.Store}
+	h.Store = store
+	h.UploadWorkers = 4
+
+	// Первые чанки загружаются дольше следующих
+	fs.onUpload = func(chunkID string, data []byte) {
+		time.Sleep(time.Duration(8-data[0]) * 5 * time.Millisecond)
+	}
+
+	policy := metastore.DurabilityPolicy{Mode: metastore.DurabilityReplication, Replicas: 1, MinReplicas: 1}
+	if err := h.Store.InitFile("file", "test.bin", 0, "fixed", policy); err != nil {
+		t.Fatal(err)
+	}
+	data := pipelineData(8)
+	cr := chunker.NewChunkReader(bytes.NewReader(data), 100)
+	defer cr.Close()
+	if err := h.uploadPipeline("file", cr, policy); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{0, 1, 2, 3, 4, 5, 6, 7}; !slices.Equal(store.indexes, want) {
+		t.Fatalf("chunks recorded in order %v, want %v", store.indexes, want)
+	}
+	meta, err := h.Store.GetFileMeta("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		if want := utils.CalculateSHA256(data[i*100 : (i+1)*100]); meta.Chunks[i][0].ChunkID != want {
+			t.Errorf("chunk %d has hash %s, want %s", i, meta.Chunks[i][0].ChunkID, want)
+		}
+	}
+}
+
+func TestUploadPipelineMemoryBudget(t *testing.T) {
+	h, fs := newTestHandler(t, "http://a")
+	h.UploadWorkers = 4
+	h.UploadMemory = 200 // Два чанка
+
+	var inFlight, peak atomic.Int32
+	fs.onUpload = func(chunkID string, data []byte) {
+		n := inFlight.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		inFlight.Add(-1)
+	}
+
+	policy := metastore.DurabilityPolicy{Mode: metastore.DurabilityReplication, Replicas: 1, MinReplicas: 1}
+	if err := h.Store.InitFile("file", "test.bin", 0, "fixed", policy); err != nil {
+		t.Fatal(err)
+	}
+	cr := chunker.NewChunkReader(bytes.NewReader(pipelineData(10)), 100)
+	defer cr.Close()
+	if err := h.uploadPipeline("file", cr, policy); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := peak.Load(); p > 2 {
+		t.Errorf("%d chunks uploaded in parallel, budget allows 2", p)
+	}
+
+	// Память всех чанков возвращается в бюджет
+	budget := h.uploadBudget()
+	deadline := time.Now().Add(time.Second)
+	for {
+		budget.mu.Lock()
+		used := budget.used
+		budget.mu.Unlock()
+		if used == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("budget still holds %d bytes", used)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUploadPipelineStopsOnError(t *testing.T) {
+	h, fs := newTestHandler(t, "http://a")
+	h.UploadWorkers = 2
+	fs.setDown("http://a", true)
+
+	policy := metastore.DurabilityPolicy{Mode: metastore.DurabilityReplication, Replicas: 1, MinReplicas: 1}
+	if err := h.Store.InitFile("file", "test.bin", 0, "fixed", policy); err != nil {
+		t.Fatal(err)
+	}
+	cr := chunker.NewChunkReader(bytes.NewReader(pipelineData(5)), 100)
+	defer cr.Close()
+	if err := h.uploadPipeline("file", cr, policy); err == nil {
+		t.Fatal("expected error when no copy can be written")
+	}
+
+	meta, err := h.Store.GetFileMeta("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Chunks) != 0 {
+		t.Errorf("recorded chunks = %v, want none", meta.Chunks)
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+)
+
+// durabilityFromRequest определяет политику надежности для загрузки.
+// Заголовки X-Durability, X-Replicas, X-Min-Replicas, X-EC-Data и X-EC-Parity
+// переопределяют значения по умолчанию.
+func (h *FileHandler) durabilityFromRequest(r *http.Request) (metastore.DurabilityPolicy, error) {
+	policy := h.Durability
+	if v := r.Header.Get("X-Durability"); v != "" {
+		policy.Mode = v
+	}
+	if policy.Mode == "" {
+		policy.Mode = metastore.DurabilityReplication
+	}
+
+	headerInt := func(name string, dst *int) {
+		if v := r.Header.Get(name); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				*dst = n
+			}
+		}
+	}
+
+	switch policy.Mode {
+	case metastore.DurabilityReplication:
+		headerInt("X-Replicas", &policy.Replicas)
+		headerInt("X-Min-Replicas", &policy.MinReplicas)
+		if policy.Replicas <= 0 {
+			policy.Replicas = 2
+		}
+		if r.Header.Get("X-Min-Replicas") != "" && policy.MinReplicas > policy.Replicas {
+			return policy, fmt.Errorf("X-Min-Replicas %d exceeds replica count %d", policy.MinReplicas, policy.Replicas)
+		}
+		if policy.MinReplicas <= 0 || policy.MinReplicas > policy.Replicas {
+			policy.MinReplicas = policy.Replicas
+		}
+		policy.DataShards, policy.ParityShards = 0, 0
+	case metastore.DurabilityErasure:
+		headerInt("X-EC-Data", &policy.DataShards)
+		headerInt("X-EC-Parity", &policy.ParityShards)
+		if policy.DataShards <= 0 || policy.ParityShards <= 0 {
+			return policy, fmt.Errorf("erasure coding requires data and parity shard counts")
+		}
+		if available := len(h.pool()); policy.DataShards+policy.ParityShards > available {
+			return policy, fmt.Errorf("erasure coding %d+%d requires at least %d storage nodes, have %d",
+				policy.DataShards, policy.ParityShards, policy.DataShards+policy.ParityShards, available)
+		}
+		policy.Replicas, policy.MinReplicas = 0, 0
+	default:
+		return policy, fmt.Errorf("unknown durability mode: %s", policy.Mode)
+	}
+
+	return policy, nil
+}
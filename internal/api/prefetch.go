@@ -0,0 +1,124 @@
+package api
+
+import (
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+)
+
+// fetch скачивание одного чанка в фоне
+type fetch struct {
+	index int
+	size  int64
+	done  chan struct{}
+	data  []byte
+	err   error
+}
+
+// prefetcher скачивает следующие чанки файла параллельно с отдачей текущего.
+// Чанки отдаются строго по порядку, каждый проверяется по хешу в readChunk.
+type prefetcher struct {
+	h      *FileHandler
+	meta   *metastore.FileMeta
+	depth  int
+	last   int // Последний чанк, который скачивается заранее
+	budget *memoryBudget
+
+	window  []*fetch // Запущенные скачивания по возрастанию индекса
+	current *fetch   // Чанк, который сейчас отдается клиенту
+}
+
+// newPrefetcher создает prefetcher для файла. Чанки после last заранее
+// не скачиваются.
+func newPrefetcher(h *FileHandler, meta *metastore.FileMeta, last int) *prefetcher {
+	depth := h.PrefetchDepth
+	if depth <= 0 {
+		depth = 1
+	}
+	return &prefetcher{h: h, meta: meta, depth: depth, last: last, budget: h.prefetchBudget()}
+}
+
+// get возвращает данные чанка i и запускает скачивание следующих чанков
+func (p *prefetcher) get(i int) ([]byte, error) {
+	// Непоследовательный доступ (Seek): начинаем окно заново
+	if len(p.window) == 0 || p.window[0].index != i {
+		p.discard()
+		p.start(i, true)
+	}
+
+	f := p.window[0]
+	p.window = p.window[1:]
+	p.fill(i + 1)
+
+	<-f.done
+
+	p.retire(p.current)
+	p.current = f
+	return f.data, f.err
+}
+
+// fill запускает скачивание чанков начиная с next, пока позволяют глубина
+// и бюджет, но не дальше последнего чанка диапазона
+func (p *prefetcher) fill(next int) {
+	if n := len(p.window); n > 0 {
+		next = p.window[n-1].index + 1
+	}
+
+	for len(p.window) < p.depth && next <= p.last && next < p.meta.TotalChunks {
+		if !p.start(next, false) {
+			return
+		}
+		next++
+	}
+}
+
+// start запускает скачивание чанка i. Если force, бюджет может быть превышен.
+func (p *prefetcher) start(i int, force bool) bool {
+	var size int64
+	if replicas := p.meta.Chunks[i]; len(replicas) > 0 {
+		size = replicas[0].Size
+	}
+
+	if force {
+		p.budget.acquire(size)
+	} else if !p.budget.tryAcquire(size) {
+		return false
+	}
+
+	f := &fetch{index: i, size: size, done: make(chan struct{})}
+	p.window = append(p.window, f)
+
+	// Реплику выбирает readChunk с учетом текущей нагрузки серверов, поэтому
+	// параллельные скачивания соседних чанков расходятся по разным репликам
+	replicas := p.meta.Chunks[i]
+	go func() {
+		defer close(f.done)
+		f.data, f.err = p.h.readChunk(replicas)
+	}()
+	return true
+}
+
+// retire освобождает память чанка после завершения его скачивания
+func (p *prefetcher) retire(f *fetch) {
+	if f == nil {
+		return
+	}
+	go func() {
+		<-f.done
+		f.data = nil
+		p.budget.release(f.size)
+	}()
+}
+
+// discard отменяет все запущенные скачивания
+func (p *prefetcher) discard() {
+	for _, f := range p.window {
+		p.retire(f)
+	}
+	p.window = nil
+}
+
+// close освобождает все ресурсы
+func (p *prefetcher) close() {
+	p.discard()
+	p.retire(p.current)
+	p.current = nil
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+)
+
+// fileReader предоставляет io.ReadSeeker поверх частей файла, чтобы
+// http.ServeContent мог обслуживать запросы Range. Скачиваются только части,
+// покрывающие запрошенный диапазон.
+//
+// Часть, целиком входящая в запрошенный диапазон, скачивается с проверкой
+// хеша и переходом на другую реплику при ошибке. Из частей, в которых
+// диапазон начинается или заканчивается, запрашивается у сервера хранения
+// только нужный диапазон, чтобы короткий запрос не тянул весь чанк по сети.
+// Заранее скачиваются только части до конца диапазона.
+type fileReader struct {
+	h      *FileHandler
+	meta   *metastore.FileMeta
+	offset int64
+	end    int64 // Конец запрошенного диапазона
+
+	cur      int         // Индекс закешированной части (-1, если кеш пуст)
+	data     []byte      // Данные закешированной части
+	prefetch *prefetcher // Параллельное скачивание следующих частей
+
+	stream    io.ReadCloser // Поток с частью диапазона одной части
+	streamPos int64         // Позиция в файле, с которой продолжается stream
+	streamEnd int64         // Конец данных, которые отдает stream
+
+	err error // Первая ошибка чтения
+}
+
+// newFileReader создает fileReader для полностью загруженного файла.
+// end - конец запрошенного диапазона; чтение дальше него возможно, но
+// без предварительного скачивания. Часть, в которой диапазон заканчивается,
+// читается диапазоном и заранее не скачивается.
+func newFileReader(h *FileHandler, meta *metastore.FileMeta, end int64) *fileReader {
+	fr := &fileReader{h: h, meta: meta, end: end, cur: -1}
+	last := -1
+	if end > 0 {
+		last = fr.chunkAt(end - 1)
+		if end < fr.chunkEnd(last) {
+			last--
+		}
+	}
+	fr.prefetch = newPrefetcher(h, meta, last)
+	return fr
+}
+
+// Read читает данные начиная с текущей позиции
+func (fr *fileReader) Read(p []byte) (int, error) {
+	n, err := fr.read(p)
+	if err != nil && err != io.EOF && fr.err == nil {
+		fr.err = err
+	}
+	return n, err
+}
+
+// read выполняет чтение для Read
+func (fr *fileReader) read(p []byte) (int, error) {
+	if fr.offset >= fr.meta.Size {
+		return 0, io.EOF
+	}
+
+	i := fr.chunkAt(fr.offset)
+	start := fr.offset - fr.meta.ChunkOffsets[i]
+	partial := start > 0 || (fr.offset < fr.end && fr.end < fr.chunkEnd(i))
+
+	if i != fr.cur && partial {
+		n, err := fr.readStream(i, p)
+		if err == nil {
+			return n, nil
+		}
+		log.Printf("Range read of chunk %d of file %s failed, fetching whole chunk: %v", i, fr.meta.FileID, err)
+	}
+
+	if i != fr.cur {
+		data, err := fr.prefetch.get(i)
+		if err != nil {
+			return 0, fmt.Errorf("chunk %d of file %s: %w", i, fr.meta.FileID, err)
+		}
+		fr.cur, fr.data = i, data
+	}
+
+	if start >= int64(len(fr.data)) {
+		return 0, fmt.Errorf("chunk %d of file %s is shorter than expected", i, fr.meta.FileID)
+	}
+
+	n := copy(p, fr.data[start:])
+	fr.offset += int64(n)
+	return n, nil
+}
+
+// readStream читает данные части i с текущей позиции через поток с диапазоном
+func (fr *fileReader) readStream(i int, p []byte) (int, error) {
+	if fr.stream == nil || fr.streamPos != fr.offset {
+		fr.closeStream()
+
+		stream, end, err := fr.openStream(i)
+		if err != nil {
+			return 0, err
+		}
+		fr.stream, fr.streamPos, fr.streamEnd = stream, fr.offset, end
+	}
+
+	if max := fr.streamEnd - fr.offset; int64(len(p)) > max {
+		p = p[:max]
+	}
+
+	n, err := fr.stream.Read(p)
+	fr.offset += int64(n)
+	fr.streamPos = fr.offset
+	if err == io.EOF && fr.offset == fr.streamEnd {
+		fr.closeStream()
+		err = nil
+	}
+	if n > 0 {
+		return n, nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	fr.closeStream()
+	return 0, err
+}
+
+// openStream открывает поток с текущей позиции реплицированной части i
+// до конца запрошенного диапазона или до конца части
+func (fr *fileReader) openStream(i int) (io.ReadCloser, int64, error) {
+	replicas := fr.meta.Chunks[i]
+	if len(replicas) == 0 || len(replicas[0].Shards) > 0 {
+		return nil, 0, errors.New("chunk does not support range reads")
+	}
+
+	chunkStart := fr.meta.ChunkOffsets[i]
+	end := fr.chunkEnd(i)
+	if fr.offset < fr.end && fr.end < end {
+		end = fr.end
+	}
+	off := fr.offset - chunkStart
+
+	var lastErr error
+	for _, replica := range fr.h.rankReplicas(replicas) {
+		stream, err := fr.h.Storage.DownloadChunkRange(replica.ChunkID, replica.NodeURL, off, end-fr.offset)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return stream, end, nil
+	}
+	return nil, 0, lastErr
+}
+
+// closeStream закрывает открытый поток
+func (fr *fileReader) closeStream() {
+	if fr.stream != nil {
+		fr.stream.Close()
+		fr.stream = nil
+	}
+}
+
+// Close освобождает ресурсы чтения
+func (fr *fileReader) Close() error {
+	fr.closeStream()
+	fr.prefetch.close()
+	fr.data = nil
+	return nil
+}
+
+// Seek изменяет текущую позицию
+func (fr *fileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += fr.offset
+	case io.SeekEnd:
+		offset += fr.meta.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	fr.offset = offset
+	return offset, nil
+}
+
+// chunkEnd возвращает смещение конца части i в файле
+func (fr *fileReader) chunkEnd(i int) int64 {
+	if i+1 < len(fr.meta.ChunkOffsets) {
+		return fr.meta.ChunkOffsets[i+1]
+	}
+	return fr.meta.Size
+}
+
+// rangeEnd возвращает конец байтов файла размером size, которые запрашивает
+// заголовок Range. Для нескольких диапазонов берется наибольший конец. Если
+// диапазон не указан, не разобран или If-Range не совпадает с etag, будет
+// отдан весь файл.
+func rangeEnd(r *http.Request, etag string, size int64) int64 {
+	spec, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !ok {
+		return size
+	}
+	if v := r.Header.Get("If-Range"); v != "" && v != etag {
+		return size
+	}
+
+	var end int64
+	for _, part := range strings.Split(spec, ",") {
+		first, last, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return size
+		}
+		if first == "" || last == "" {
+			// Суффикс или диапазон до конца файла
+			return size
+		}
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return size
+		}
+		end = max(end, min(n+1, size))
+	}
+	return end
+}
+
+// chunkAt возвращает индекс части, содержащей байт с указанным смещением.
+// Пустые части имеют то же смещение, что и следующая за ними, поэтому
+// берется последняя часть с offsets[i] <= offset.
+func (fr *fileReader) chunkAt(offset int64) int {
+	offsets := fr.meta.ChunkOffsets
+	return sort.Search(len(offsets), func(i int) bool { return offsets[i] > offset }) - 1
+}
+
+// deferredWriter откладывает отправку заголовков до первой записи тела, чтобы
+// при недоступной первой части диапазона клиент получил ошибку, а не
+// обрезанный ответ с кодом 200
+type deferredWriter struct {
+	http.ResponseWriter
+	status  int
+	written bool
+}
+
+// WriteHeader запоминает код ответа до первой записи тела
+func (dw *deferredWriter) WriteHeader(status int) {
+	if dw.written {
+		return
+	}
+	dw.status = status
+}
+
+// Write отправляет отложенные заголовки и записывает тело
+func (dw *deferredWriter) Write(p []byte) (int, error) {
+	dw.flush()
+	return dw.ResponseWriter.Write(p)
+}
+
+// flush отправляет отложенные заголовки
+func (dw *deferredWriter) flush() {
+	if dw.written {
+		return
+	}
+	dw.written = true
+	if dw.status != 0 {
+		dw.ResponseWriter.WriteHeader(dw.status)
+	}
+}
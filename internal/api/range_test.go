@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDownloadRangeFetchesOnlyRange(t *testing.T) {
+	const node = "http://node1"
+	h, fs := newTestHandler(t, node)
+
+	data := make([]byte, 450)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	c := putFile(t, h, fs, "file", node, data, 100)
+
+	tests := []struct {
+		name  string
+		rng   string
+		body  []byte
+		calls []string
+	}{
+		{
+			name:  "start of first chunk",
+			rng:   "bytes=0-99",
+			body:  data[:100],
+			calls: []string{"get " + c[0]},
+		},
+		{
+			name:  "inside first chunk",
+			rng:   "bytes=0-9",
+			body:  data[:10],
+			calls: []string{"range " + c[0] + " 0 10"},
+		},
+		{
+			name:  "across chunks",
+			rng:   "bytes=150-349",
+			body:  data[150:350],
+			calls: []string{"range " + c[1] + " 50 50", "get " + c[2], "range " + c[3] + " 0 50"},
+		},
+		{
+			name:  "tail",
+			rng:   "bytes=420-",
+			body:  data[420:],
+			calls: []string{"range " + c[4] + " 20 30"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/download?fileID=file", nil)
+			req.Header.Set("Range", tt.rng)
+			rec := httptest.NewRecorder()
+			h.Download(rec, req)
+
+			if rec.Code != http.StatusPartialContent {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusPartialContent, rec.Body)
+			}
+			if !bytes.Equal(rec.Body.Bytes(), tt.body) {
+				t.Fatalf("body mismatch: got %d bytes, want %d", rec.Body.Len(), len(tt.body))
+			}
+
+			// Даем завершиться скачиваниям, которые могли быть запущены заранее
+			time.Sleep(20 * time.Millisecond)
+			calls := fs.downloads()
+			if fmt.Sprint(calls) != fmt.Sprint(tt.calls) {
+				t.Errorf("downloads = %q, want %q", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestDownloadWholeFile(t *testing.T) {
+	const node = "http://node1"
+	h, fs := newTestHandler(t, node)
+
+	data := make([]byte, 450)
+	for i := range data {
+		data[i] = byte(i * 3)
+	}
+	putFile(t, h, fs, "file", node, data, 100)
+
+	req := httptest.NewRequest(http.MethodGet, "/download?fileID=file", nil)
+	rec := httptest.NewRecorder()
+	h.Download(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), data) {
+		t.Fatalf("body mismatch: got %d bytes, want %d", rec.Body.Len(), len(data))
+	}
+	if calls := fs.downloads(); len(calls) != 5 {
+		t.Errorf("downloads = %q, want 5 whole chunks", calls)
+	}
+}
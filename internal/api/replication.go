@@ -0,0 +1,145 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/placement"
+)
+
+// lateReplicas копии чанка, дописанные после подтверждения кворума
+type lateReplicas struct {
+	done     chan struct{}         // Закрывается после завершения всех загрузок
+	replicas []metastore.ChunkInfo // Копии сверх кворума, доступны после done
+}
+
+// uploadReplicas параллельно загружает копии чанка на выбранные серверы.
+// Если сервер недоступен, копия отправляется на следующий по предпочтению
+// сервер из пула. Чанк считается записанным, как только подтверждено
+// policy.MinReplicas копий: остальные загрузки продолжаются в фоне,
+// а их копии возвращаются через lateReplicas.
+func (h *FileHandler) uploadReplicas(hash string, chunk []byte, policy metastore.DurabilityPolicy) ([]metastore.ChunkInfo, *lateReplicas, error) {
+	ranked := h.rank(hash)
+	nodes, fallback := ranked, []string(nil)
+	if policy.Replicas < len(ranked) {
+		nodes, fallback = ranked[:policy.Replicas], ranked[policy.Replicas:]
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		failures []error
+	)
+	// Каждый загрузчик записывает не больше одной копии
+	copies := make(chan metastore.ChunkInfo, len(nodes))
+
+	// nextFallback выдает следующий запасной сервер
+	nextFallback := func() (string, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(fallback) == 0 {
+			return "", false
+		}
+		node := fallback[0]
+		fallback = fallback[1:]
+		return node, true
+	}
+
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			for {
+				err := h.Storage.UploadChunk(hash, node, chunk)
+				if err == nil {
+					copies <- metastore.ChunkInfo{ChunkID: hash, NodeURL: node, Size: int64(len(chunk))}
+					return
+				}
+
+				mu.Lock()
+				failures = append(failures, fmt.Errorf("%s: %w", node, err))
+				mu.Unlock()
+
+				var ok bool
+				if node, ok = nextFallback(); !ok {
+					return
+				}
+			}
+		}(node)
+	}
+	go func() {
+		wg.Wait()
+		close(copies)
+	}()
+
+	var written []metastore.ChunkInfo
+	for ci := range copies {
+		written = append(written, ci)
+		if len(written) < policy.MinReplicas {
+			continue
+		}
+
+		// Кворум набран: остальные копии дописываются в фоне
+		late := &lateReplicas{done: make(chan struct{})}
+		go func() {
+			defer close(late.done)
+			for ci := range copies {
+				late.replicas = append(late.replicas, ci)
+			}
+			all := make([]string, 0, len(written)+len(late.replicas))
+			for _, ci := range append(written[:len(written):len(written)], late.replicas...) {
+				all = append(all, ci.NodeURL)
+			}
+			h.checkSpread(hash, all, 1)
+		}()
+		return written, late, nil
+	}
+
+	if len(failures) == 0 {
+		// Серверы исключены из размещения: недоступны или заполнены
+		return written, nil, fmt.Errorf("write quorum not met for chunk %s: only %d storage nodes accept writes, %d copies required",
+			hash, len(ranked), policy.MinReplicas)
+	}
+	return written, nil, fmt.Errorf("write quorum not met for chunk %s: %d of %d required copies written: %w",
+		hash, len(written), policy.MinReplicas, errors.Join(failures...))
+}
+
+// recordLate дожидается копий чанка, записанных после подтверждения кворума,
+// и добавляет их в размещение. Если файл к этому времени удален, копии
+// остаются сиротами и удаляются сверкой.
+func (h *FileHandler) recordLate(fileID string, index int, p chunkPlacement) {
+	<-p.late.done
+	if len(p.late.replicas) == 0 {
+		return
+	}
+
+	ref := []metastore.ChunkRef{{FileID: fileID, Index: index}}
+	err := h.Store.UpdateChunk(p.hash, ref, func(replicas []metastore.ChunkInfo) []metastore.ChunkInfo {
+		for _, ci := range p.late.replicas {
+			if !slices.ContainsFunc(replicas, func(r metastore.ChunkInfo) bool { return r.NodeURL == ci.NodeURL }) {
+				replicas = append(replicas, ci)
+			}
+		}
+		return replicas
+	})
+	if err != nil {
+		log.Printf("Failed to record late copies of chunk %s: %v", p.hash, err)
+	}
+}
+
+// checkSpread сообщает, если потеря одного failure domain приведет к потере
+// больше tolerated копий чанка. Так бывает, когда различных domain меньше,
+// чем копий.
+func (h *FileHandler) checkSpread(hash string, nodes []string, tolerated int) {
+	if h.DomainLevel == "" {
+		return
+	}
+	domain, count := placement.Crowded(h.StoragePool.Domains(), h.DomainLevel, nodes)
+	if count > tolerated {
+		log.Printf("Warning: chunk %s has %d copies in %s %q: not enough failure domains", hash, count, h.DomainLevel, domain)
+	}
+}
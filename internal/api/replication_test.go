@@ -0,0 +1,158 @@
+package api
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/utils"
+)
+
+// poolOrder размещает чанки на серверы в порядке пула
+type poolOrder struct{}
+
+func (poolOrder) Rank(key string, nodes []string) []string { return nodes }
+
+// nodeURLs возвращает серверы копий
+func nodeURLs(replicas []metastore.ChunkInfo) []string {
+	nodes := make([]string, len(replicas))
+	for i, ci := range replicas {
+		nodes[i] = ci.NodeURL
+	}
+	return nodes
+}
+
+func TestUploadReplicasQuorum(t *testing.T) {
+	h, fs := newTestHandler(t, "http://a", "http://b", "http://c", "http://d")
+	h.Placement = poolOrder{}
+	fs.setDelay("http://c", 300*time.Millisecond)
+
+	chunk := []byte("quorum chunk")
+	hash := utils.CalculateSHA256(chunk)
+	policy := metastore.DurabilityPolicy{Mode: metastore.DurabilityReplication, Replicas: 3, MinReplicas: 2}
+
+	started := time.Now()
+	written, late, err := h.uploadReplicas(hash, chunk, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed > 200*time.Millisecond {
+		t.Errorf("quorum acknowledged after %v, should not wait for the slow copy", elapsed)
+	}
+	if got := nodeURLs(written); len(got) != 2 || !slices.Contains(got, "http://a") || !slices.Contains(got, "http://b") {
+		t.Fatalf("written = %v, want a and b", got)
+	}
+
+	<-late.done
+	if got := nodeURLs(late.replicas); !slices.Equal(got, []string{"http://c"}) {
+		t.Fatalf("late = %v, want [http://c]", got)
+	}
+}
+
+func TestUploadReplicasFallback(t *testing.T) {
+	h, fs := newTestHandler(t, "http://a", "http://b", "http://c", "http://d")
+	h.Placement = poolOrder{}
+	fs.setDown("http://a", true)
+
+	chunk := []byte("fallback chunk")
+	hash := utils.CalculateSHA256(chunk)
+	policy := metastore.DurabilityPolicy{Mode: metastore.DurabilityReplication, Replicas: 2, MinReplicas: 2}
+
+	written, late, err := h.uploadReplicas(hash, chunk, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-late.done
+
+	// Копия с недоступного сервера уходит на следующий по рангу
+	got := append(nodeURLs(written), nodeURLs(late.replicas)...)
+	slices.Sort(got)
+	if !slices.Equal(got, []string{"http://b", "http://c"}) {
+		t.Fatalf("copies = %v, want [http://b http://c]", got)
+	}
+}
+
+func TestUploadReplicasQuorumNotMet(t *testing.T) {
+	h, fs := newTestHandler(t, "http://a", "http://b", "http://c")
+	h.Placement = poolOrder{}
+	fs.setDown("http://b", true)
+	fs.setDown("http://c", true)
+
+	chunk := []byte("lost chunk")
+	hash := utils.CalculateSHA256(chunk)
+	policy := metastore.DurabilityPolicy{Mode: metastore.DurabilityReplication, Replicas: 2, MinReplicas: 2}
+
+	written, late, err := h.uploadReplicas(hash, chunk, policy)
+	if err == nil {
+		t.Fatal("expected write quorum error")
+	}
+	if late != nil {
+		t.Error("late copies returned for a failed write")
+	}
+	if got := nodeURLs(written); !slices.Equal(got, []string{"http://a"}) {
+		t.Fatalf("written = %v, want [http://a]", got)
+	}
+}
+
+func TestStoreChunkRecordsLateCopies(t *testing.T) {
+	h, fs := newTestHandler(t, "http://a", "http://b", "http://c")
+	h.Placement = poolOrder{}
+	fs.setDelay("http://c", 100*time.Millisecond)
+
+	policy := metastore.DurabilityPolicy{Mode: metastore.DurabilityReplication, Replicas: 3, MinReplicas: 1}
+	if err := h.Store.InitFile("file", "test.bin", 0, "fixed", policy); err != nil {
+		t.Fatal(err)
+	}
+	chunk := []byte("late chunk")
+	if err := h.storeChunk("file", 0, utils.CalculateSHA256(chunk), chunk, policy); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		meta, err := h.Store.GetFileMeta("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(meta.Chunks[0]) == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("recorded copies = %v, want all 3", nodeURLs(meta.Chunks[0]))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDurabilityRejectsMinReplicasAboveReplicas(t *testing.T) {
+	h, _ := newTestHandler(t, "http://a", "http://b")
+	h.Durability = metastore.DurabilityPolicy{Replicas: 3, MinReplicas: 3}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		min     int
+		wantErr bool
+	}{
+		{"explicit above replicas", map[string]string{"X-Replicas": "2", "X-Min-Replicas": "3"}, 0, true},
+		{"explicit within replicas", map[string]string{"X-Replicas": "3", "X-Min-Replicas": "2"}, 2, false},
+		{"default clamped to replicas", map[string]string{"X-Replicas": "1"}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/upload", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			policy, err := h.durabilityFromRequest(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && policy.MinReplicas != tt.min {
+				t.Errorf("MinReplicas = %d, want %d", policy.MinReplicas, tt.min)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/utils"
+	"github.com/google/uuid"
+)
+
+// chunkingClient алгоритм разбиения для сессионных загрузок: границы чанков задает клиент
+const chunkingClient = "client"
+
+// uploadChunkInfo описывает принятый чанк сессионной загрузки
+type uploadChunkInfo struct {
+	Index int    `json:"index"`
+	Hash  string `json:"hash"`
+	Size  int64  `json:"size"`
+}
+
+// CreateUpload начинает сессионную загрузку и возвращает ее идентификатор.
+// Идентификатор загрузки совпадает с идентификатором будущего файла.
+func (h *FileHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	fileID := uuid.NewString()
+
+	filename := r.Header.Get("X-Filename")
+	if filename == "" {
+		filename = "uploaded.bin"
+	}
+
+	policy, err := h.durabilityFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Store.InitFile(fileID, filename, 0, chunkingClient, policy); err != nil {
+		http.Error(w, "failed to init upload", http.StatusInternalServerError)
+		log.Printf("Failed to init upload: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, fileID)
+}
+
+// PutUploadChunk принимает один чанк сессионной загрузки. Повторная отправка
+// того же чанка безопасна; другое содержимое для уже принятого индекса отклоняется.
+func (h *FileHandler) PutUploadChunk(w http.ResponseWriter, r *http.Request) {
+	fileID := r.PathValue("id")
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil || index < 0 {
+		http.Error(w, "invalid chunk index", http.StatusBadRequest)
+		return
+	}
+
+	meta, ok := h.openUpload(w, fileID)
+	if !ok {
+		return
+	}
+
+	// Размер чанка ограничен размером чанка по умолчанию
+	data, err := io.ReadAll(io.LimitReader(r.Body, h.ChunkSize+1))
+	if err != nil {
+		http.Error(w, "reading failed", http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > h.ChunkSize {
+		http.Error(w, fmt.Sprintf("chunk exceeds %d bytes", h.ChunkSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	hash := utils.CalculateSHA256(data)
+	if expected := r.Header.Get("X-Chunk-SHA256"); expected != "" && expected != hash {
+		http.Error(w, "chunk hash mismatch", http.StatusBadRequest)
+		return
+	}
+
+	if existing := meta.Chunks[index]; len(existing) > 0 {
+		if existing[0].ChunkID != hash {
+			http.Error(w, "chunk already uploaded with different content", http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, hash)
+		return
+	}
+
+	// Параллельный запрос мог записать эту часть после проверки выше:
+	// окончательно конфликт проверяет SaveChunk. Копии проигравшего запроса
+	// остаются сиротами и удаляются сверкой.
+	if err := h.storeChunk(fileID, index, hash, data, meta.Durability); err != nil {
+		if errors.Is(err, metastore.ErrChunkConflict) {
+			http.Error(w, "chunk already uploaded with different content", http.StatusConflict)
+			return
+		}
+		http.Error(w, "upload failed: "+err.Error(), http.StatusBadGateway)
+		log.Printf("Failed to upload chunk %d of upload %s: %v", index, fileID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, hash)
+}
+
+// GetUpload возвращает состояние сессионной загрузки и список принятых чанков
+func (h *FileHandler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	meta, err := h.Store.GetFileMeta(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"uploadID": meta.FileID,
+		"filename": meta.Filename,
+		"complete": meta.Complete,
+		"chunks":   uploadedChunks(meta),
+	})
+}
+
+// CompleteUpload проверяет, что приняты все ожидаемые чанки, и завершает
+// загрузку. Ожидаемые чанки обязательно задаются заголовком X-Total-Chunks
+// или телом {"chunks": ["<hash>", ...]} с хешами чанков по порядку; хеши
+// сверяются с принятыми чанками. Необязательный заголовок X-Total-Size
+// задает ожидаемый размер файла.
+func (h *FileHandler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	fileID := r.PathValue("id")
+	meta, ok := h.openUpload(w, fileID)
+	if !ok {
+		return
+	}
+
+	total := -1
+	if v := r.Header.Get("X-Total-Chunks"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid X-Total-Chunks", http.StatusBadRequest)
+			return
+		}
+		total = n
+	}
+
+	var manifest struct {
+		Chunks []string `json:"chunks"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&manifest); err != nil && err != io.EOF {
+		http.Error(w, "invalid chunk manifest", http.StatusBadRequest)
+		return
+	}
+	if manifest.Chunks != nil {
+		if total >= 0 && total != len(manifest.Chunks) {
+			http.Error(w, "X-Total-Chunks does not match chunk manifest", http.StatusBadRequest)
+			return
+		}
+		total = len(manifest.Chunks)
+	}
+	if total < 0 {
+		http.Error(w, "missing X-Total-Chunks or chunk manifest", http.StatusBadRequest)
+		return
+	}
+
+	if meta.TotalChunks > total {
+		http.Error(w, fmt.Sprintf("expected %d chunks, received %d", total, meta.TotalChunks), http.StatusConflict)
+		return
+	}
+
+	// Чанки должны идти подряд и совпадать с манифестом
+	var size int64
+	for i := 0; i < total; i++ {
+		if err := checkChunk(meta.Chunks[i]); err != nil {
+			http.Error(w, fmt.Sprintf("chunk %d: %v", i, err), http.StatusConflict)
+			return
+		}
+		if manifest.Chunks != nil && meta.Chunks[i][0].ChunkID != manifest.Chunks[i] {
+			http.Error(w, fmt.Sprintf("chunk %d: hash mismatch", i), http.StatusConflict)
+			return
+		}
+		size += meta.Chunks[i][0].Size
+	}
+
+	if v := r.Header.Get("X-Total-Size"); v != "" {
+		expected, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || expected < 0 {
+			http.Error(w, "invalid X-Total-Size", http.StatusBadRequest)
+			return
+		}
+		if expected != size {
+			http.Error(w, fmt.Sprintf("expected %d bytes, received %d", expected, size), http.StatusConflict)
+			return
+		}
+	}
+
+	if err := h.Store.MarkComplete(fileID); err != nil {
+		http.Error(w, "failed to complete upload", http.StatusInternalServerError)
+		log.Printf("Failed to mark upload %s complete: %v", fileID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, fileID)
+}
+
+// openUpload возвращает метаданные незавершенной загрузки или пишет ошибку в ответ
+func (h *FileHandler) openUpload(w http.ResponseWriter, fileID string) (*metastore.FileMeta, bool) {
+	meta, err := h.Store.GetFileMeta(fileID)
+	if err != nil {
+		if errors.Is(err, metastore.ErrFileNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "failed to read upload", http.StatusInternalServerError)
+			log.Printf("Failed to read upload %s: %v", fileID, err)
+		}
+		return nil, false
+	}
+	if meta.Complete {
+		http.Error(w, "upload is already complete", http.StatusConflict)
+		return nil, false
+	}
+	return meta, true
+}
+
+// checkChunk проверяет, что чанк принят. Требуемое число копий здесь не
+// проверяется: новый чанк записывается только при выполненном кворуме записи,
+// а число копий найденного по хешу чанка поддерживает восстановление.
+func checkChunk(replicas []metastore.ChunkInfo) error {
+	if len(replicas) == 0 {
+		return fmt.Errorf("missing")
+	}
+	return nil
+}
+
+// uploadedChunks возвращает принятые чанки в порядке индексов
+func uploadedChunks(meta *metastore.FileMeta) []uploadChunkInfo {
+	chunks := make([]uploadChunkInfo, 0, len(meta.Chunks))
+	for index, replicas := range meta.Chunks {
+		if len(replicas) == 0 {
+			continue
+		}
+		chunks = append(chunks, uploadChunkInfo{Index: index, Hash: replicas[0].ChunkID, Size: replicas[0].Size})
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Index < chunks[j].Index })
+	return chunks
+}
@@ -0,0 +1,184 @@
+package chunker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math/bits"
+)
+
+// Размеры чанков по умолчанию для content-defined chunking
+const (
+	DefaultCDCMinSize int64 = 512 << 10
+	DefaultCDCAvgSize int64 = 2 << 20
+	DefaultCDCMaxSize int64 = 8 << 20
+)
+
+// gearTable таблица случайных значений для gear rolling hash.
+// Заполняется детерминированно: границы чанков не должны меняться
+// между запусками, иначе дедупликация перестанет работать.
+var gearTable [256]uint64
+
+func init() {
+	// splitmix64 с фиксированным сидом
+	seed := uint64(0x9e3779b97f4a7c15)
+	for i := range gearTable {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gearTable[i] = z ^ (z >> 31)
+	}
+}
+
+// CDCReader разбивает поток на чанки переменного размера по алгоритму FastCDC.
+// Границы чанков определяются содержимым, поэтому вставка данных в начало файла
+// меняет только соседние чанки, а остальные сохраняют свои хеши.
+type CDCReader struct {
+	r       io.Reader
+	minSize int
+	avgSize int
+	maxSize int
+	maskS   uint64 // маска до достижения среднего размера (строже)
+	maskL   uint64 // маска после среднего размера (мягче)
+
+	buf   []byte
+	start int // начало необработанных данных в buf
+	end   int // конец прочитанных данных в buf
+	eof   bool
+	done  bool
+}
+
+// NewCDCReader создает новый CDCReader поверх r. Нулевые и некорректные
+// размеры заменяются значениями по умолчанию.
+func NewCDCReader(r io.Reader, minSize, avgSize, maxSize int64) *CDCReader {
+	if avgSize <= 0 {
+		avgSize = DefaultCDCAvgSize
+	}
+	if minSize <= 0 || minSize > avgSize {
+		minSize = avgSize / 4
+	}
+	if maxSize <= 0 || maxSize < avgSize {
+		maxSize = avgSize * 4
+	}
+
+	// Нормализованное разбиение: до среднего размера требуем на 2 бита больше,
+	// после - на 2 бита меньше, чтобы размеры группировались вокруг avgSize
+	b := bits.Len64(uint64(avgSize)) - 1
+
+	return &CDCReader{
+		r:       r,
+		minSize: int(minSize),
+		avgSize: int(avgSize),
+		maxSize: int(maxSize),
+		maskS:   topMask(b + 2),
+		maskL:   topMask(b - 2),
+	}
+}
+
+// topMask возвращает маску из n старших бит
+func topMask(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	if n >= 64 {
+		return ^uint64(0)
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+// NextChunk читает следующий чанк и возвращает его данные и SHA-256 хеш.
+// Контракт совпадает с ChunkReader.NextChunk.
+func (cr *CDCReader) NextChunk() ([]byte, string, error) {
+	if cr.done {
+		cr.Close()
+		return nil, "", io.EOF
+	}
+
+	if cr.buf == nil {
+		cr.buf = getBuffer(int64(cr.maxSize))
+	}
+
+	if err := cr.fill(); err != nil {
+		return nil, "", err
+	}
+
+	if cr.start == cr.end {
+		cr.Close()
+		return nil, "", io.EOF
+	}
+
+	n := cr.cut(cr.buf[cr.start:cr.end])
+	data := cr.buf[cr.start : cr.start+n]
+	cr.start += n
+
+	if cr.eof && cr.start == cr.end {
+		cr.done = true
+	}
+
+	sum := sha256.Sum256(data)
+	return data, hex.EncodeToString(sum[:]), nil
+}
+
+// fill сдвигает необработанные данные в начало буфера и дочитывает его до maxSize
+func (cr *CDCReader) fill() error {
+	if cr.start > 0 {
+		cr.end = copy(cr.buf, cr.buf[cr.start:cr.end])
+		cr.start = 0
+	}
+
+	for !cr.eof && cr.end < cr.maxSize {
+		n, err := cr.r.Read(cr.buf[cr.end:cr.maxSize])
+		cr.end += n
+		if err == io.EOF {
+			cr.eof = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cut возвращает длину следующего чанка в data
+func (cr *CDCReader) cut(data []byte) int {
+	n := len(data)
+	if n <= cr.minSize {
+		return n
+	}
+	if n > cr.maxSize {
+		n = cr.maxSize
+	}
+
+	normal := cr.avgSize
+	if normal > n {
+		normal = n
+	}
+
+	var fp uint64
+	i := cr.minSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&cr.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&cr.maskL == 0 {
+			return i + 1
+		}
+	}
+
+	return n
+}
+
+// Close возвращает буфер в пул. После Close NextChunk возвращает io.EOF.
+func (cr *CDCReader) Close() error {
+	cr.done = true
+	putBuffer(cr.buf)
+	cr.buf = nil
+	return nil
+}
@@ -0,0 +1,105 @@
+package chunker
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+const (
+	testCDCMin = 2 << 10
+	testCDCAvg = 8 << 10
+	testCDCMax = 32 << 10
+)
+
+// cdcChunks разбивает data на чанки CDC и возвращает их хеши и размеры
+func cdcChunks(t *testing.T, data []byte) ([]string, []int) {
+	t.Helper()
+	cr := NewCDCReader(bytes.NewReader(data), testCDCMin, testCDCAvg, testCDCMax)
+	defer cr.Close()
+
+	var hashes []string
+	var sizes []int
+	for {
+		chunk, hash, err := cr.NextChunk()
+		if err == io.EOF {
+			return hashes, sizes
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+		sizes = append(sizes, len(chunk))
+	}
+}
+
+func TestCDCSizeBounds(t *testing.T) {
+	data := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	_, sizes := cdcChunks(t, data)
+
+	total := 0
+	for i, size := range sizes {
+		total += size
+		// Последний чанк может быть короче минимального
+		if size > testCDCMax || (size < testCDCMin && i != len(sizes)-1) {
+			t.Fatalf("chunk %d has size %d, want within [%d, %d]", i, size, testCDCMin, testCDCMax)
+		}
+	}
+	if total != len(data) {
+		t.Fatalf("chunks cover %d bytes, want %d", total, len(data))
+	}
+
+	avg := total / len(sizes)
+	if avg < testCDCAvg/2 || avg > testCDCAvg*2 {
+		t.Errorf("average chunk size %d is far from %d", avg, testCDCAvg)
+	}
+}
+
+func TestCDCResyncAfterShift(t *testing.T) {
+	data := make([]byte, 4<<20)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	for _, shift := range []int{1, 3, 7, 100} {
+		shifted := append(make([]byte, shift), data...)
+		rand.New(rand.NewSource(int64(shift))).Read(shifted[:shift])
+
+		original, _ := cdcChunks(t, data)
+		moved, _ := cdcChunks(t, shifted)
+
+		seen := make(map[string]bool, len(moved))
+		for _, hash := range moved {
+			seen[hash] = true
+		}
+		shared := 0
+		for _, hash := range original {
+			if seen[hash] {
+				shared++
+			}
+		}
+
+		// Вставка в начало меняет только первые чанки
+		if shared < len(original)-2 {
+			t.Errorf("shift by %d bytes: %d of %d chunks shared, want at least %d",
+				shift, shared, len(original), len(original)-2)
+		}
+	}
+}
+
+func TestCDCDeterministic(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(3)).Read(data)
+
+	first, _ := cdcChunks(t, data)
+	second, _ := cdcChunks(t, data)
+	if len(first) != len(second) {
+		t.Fatalf("chunk counts differ: %d and %d", len(first), len(second))
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("chunk %d differs between runs", i)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package chunker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"sync"
+)
+
+// DefaultChunkSize размер чанка по умолчанию (64 МиБ)
+const DefaultChunkSize int64 = 64 << 20
+
+// bufferPool пул буферов для чтения чанков, чтобы не аллоцировать
+// по 64 МиБ на каждый запрос
+var bufferPool sync.Pool
+
+// getBuffer возвращает буфер из пула емкостью не меньше size
+func getBuffer(size int64) []byte {
+	if v := bufferPool.Get(); v != nil {
+		buf := *(v.(*[]byte))
+		if int64(cap(buf)) >= size {
+			return buf[:size]
+		}
+	}
+	return make([]byte, size)
+}
+
+// putBuffer возвращает буфер в пул
+func putBuffer(buf []byte) {
+	if buf == nil {
+		return
+	}
+	buf = buf[:0]
+	bufferPool.Put(&buf)
+}
+
+// ChunkReader разбивает поток на чанки фиксированного размера
+// и вычисляет SHA-256 каждого чанка за один проход
+type ChunkReader struct {
+	r         io.Reader
+	chunkSize int64
+	buf       []byte
+	hasher    hash.Hash
+	done      bool
+}
+
+// NewChunkReader создает новый ChunkReader поверх r.
+// Если chunkSize <= 0, используется DefaultChunkSize.
+func NewChunkReader(r io.Reader, chunkSize int64) *ChunkReader {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return &ChunkReader{
+		r:         r,
+		chunkSize: chunkSize,
+		hasher:    sha256.New(),
+	}
+}
+
+// NextChunk читает следующий чанк и возвращает его данные и SHA-256 хеш.
+// Все чанки, кроме последнего, имеют размер ровно chunkSize байт; последний
+// может быть короче. Когда данные закончились, возвращается io.EOF (в том числе
+// при первом вызове на пустом потоке). Возвращаемый срез действителен только
+// до следующего вызова NextChunk или Close.
+func (cr *ChunkReader) NextChunk() ([]byte, string, error) {
+	if cr.done {
+		cr.Close()
+		return nil, "", io.EOF
+	}
+
+	if cr.buf == nil {
+		cr.buf = getBuffer(cr.chunkSize)
+	}
+
+	// Хеш считается по мере чтения, без повторного прохода по буферу.
+	// io.ReadFull не подходит: он превращает оборванный поток
+	// (io.ErrUnexpectedEOF от источника) в обычный короткий чанк.
+	cr.hasher.Reset()
+	r := io.TeeReader(cr.r, cr.hasher)
+	n := 0
+	for int64(n) < cr.chunkSize {
+		m, err := r.Read(cr.buf[n:cr.chunkSize])
+		n += m
+		if err == io.EOF {
+			cr.done = true
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if n == 0 {
+		// Данных больше нет, чанк пустой
+		cr.Close()
+		return nil, "", io.EOF
+	}
+
+	return cr.buf[:n], hex.EncodeToString(cr.hasher.Sum(nil)), nil
+}
+
+// Close возвращает буфер в пул. После Close NextChunk возвращает io.EOF.
+func (cr *ChunkReader) Close() error {
+	cr.done = true
+	putBuffer(cr.buf)
+	cr.buf = nil
+	return nil
+}
@@ -0,0 +1,92 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestChunkReader(t *testing.T) {
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	tests := []struct {
+		name      string
+		src       io.Reader
+		chunkSize int64
+		sizes     []int // Ожидаемые размеры чанков
+		err       error // Ожидаемая ошибка после чанков (nil - io.EOF)
+	}{
+		{"empty body", bytes.NewReader(nil), 1000, nil, nil},
+		{"exact multiple", bytes.NewReader(data), 2500, []int{2500, 2500, 2500, 2500}, nil},
+		{"short final chunk", bytes.NewReader(data), 3000, []int{3000, 3000, 3000, 1000}, nil},
+		{"one byte reads", iotest.OneByteReader(bytes.NewReader(data[:10])), 4, []int{4, 4, 2}, nil},
+		{"zero chunk size", bytes.NewReader(data), 0, []int{10000}, nil},
+		{"negative chunk size", bytes.NewReader(data), -1, []int{10000}, nil},
+		{
+			"truncated body",
+			io.MultiReader(bytes.NewReader(data[:2500]), iotest.ErrReader(io.ErrUnexpectedEOF)),
+			1000, []int{1000, 1000}, io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := NewChunkReader(tt.src, tt.chunkSize)
+			defer cr.Close()
+
+			offset := 0
+			for i := 0; ; i++ {
+				chunk, hash, err := cr.NextChunk()
+				if err != nil {
+					want := tt.err
+					if want == nil {
+						want = io.EOF
+					}
+					if !errors.Is(err, want) {
+						t.Fatalf("chunk %d: got error %v, want %v", i, err, want)
+					}
+					if i != len(tt.sizes) {
+						t.Fatalf("got %d chunks, want %d", i, len(tt.sizes))
+					}
+					return
+				}
+
+				if i >= len(tt.sizes) {
+					t.Fatalf("unexpected chunk %d of %d bytes", i, len(chunk))
+				}
+				if len(chunk) != tt.sizes[i] {
+					t.Fatalf("chunk %d: got %d bytes, want %d", i, len(chunk), tt.sizes[i])
+				}
+				if !bytes.Equal(chunk, data[offset:offset+len(chunk)]) {
+					t.Fatalf("chunk %d: data mismatch", i)
+				}
+				sum := sha256.Sum256(chunk)
+				if hash != hex.EncodeToString(sum[:]) {
+					t.Fatalf("chunk %d: hash mismatch", i)
+				}
+				offset += len(chunk)
+			}
+		})
+	}
+}
+
+func TestChunkReaderAfterEOF(t *testing.T) {
+	cr := NewChunkReader(bytes.NewReader([]byte("abc")), 2)
+	for i := 0; i < 2; i++ {
+		if _, _, err := cr.NextChunk(); err != nil {
+			t.Fatalf("chunk %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := cr.NextChunk(); err != io.EOF {
+			t.Fatalf("got %v after the last chunk, want io.EOF", err)
+		}
+	}
+}
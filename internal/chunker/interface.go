@@ -0,0 +1,43 @@
+package chunker
+
+import (
+	"fmt"
+	"io"
+)
+
+// Алгоритмы разбиения на чанки
+const (
+	ModeFixed = "fixed" // чанки фиксированного размера
+	ModeCDC   = "cdc"   // content-defined chunking (FastCDC)
+)
+
+// Chunker интерфейс для разбиения потока на чанки
+type Chunker interface {
+	// NextChunk возвращает данные следующего чанка и его SHA-256 хеш,
+	// либо io.EOF, когда данные закончились
+	NextChunk() ([]byte, string, error)
+
+	// Close освобождает буферы чанкера
+	Close() error
+}
+
+// Config параметры разбиения на чанки
+type Config struct {
+	Mode      string // ModeFixed или ModeCDC
+	ChunkSize int64  // Размер чанка для ModeFixed
+	MinSize   int64  // Минимальный размер чанка для ModeCDC
+	AvgSize   int64  // Средний размер чанка для ModeCDC
+	MaxSize   int64  // Максимальный размер чанка для ModeCDC
+}
+
+// New создает чанкер в соответствии с конфигурацией
+func New(r io.Reader, cfg Config) (Chunker, error) {
+	switch cfg.Mode {
+	case "", ModeFixed:
+		return NewChunkReader(r, cfg.ChunkSize), nil
+	case ModeCDC:
+		return NewCDCReader(r, cfg.MinSize, cfg.AvgSize, cfg.MaxSize), nil
+	default:
+		return nil, fmt.Errorf("unknown chunking mode: %s", cfg.Mode)
+	}
+}
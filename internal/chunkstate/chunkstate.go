@@ -0,0 +1,79 @@
+package chunkstate
+
+import (
+	"sort"
+
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+)
+
+// State собранная информация о чанке по всем файлам
+type State struct {
+	Hash   string
+	Info   metastore.ChunkInfo // Представительная запись (для erasure содержит шарды)
+	Nodes  []string            // Известные серверы с копиями (для репликации)
+	Target int                 // Целевое число копий: наибольшее по ссылающимся файлам
+	Refs   []metastore.ChunkRef
+}
+
+// Collect собирает размещение всех чанков, упорядоченное по хешу. Если
+// completeOnly, незавершенные загрузки пропускаются. Для файлов без политики
+// надежности целевым считается replicas копий.
+func Collect(store metastore.MetaStore, completeOnly bool, replicas int) ([]*State, error) {
+	byHash := make(map[string]*State)
+
+	err := store.ForEachFile(func(meta *metastore.FileMeta) error {
+		if completeOnly && !meta.Complete {
+			return nil
+		}
+
+		target := meta.Durability.Replicas
+		if target <= 0 {
+			target = replicas
+		}
+
+		for index, infos := range meta.Chunks {
+			if len(infos) == 0 {
+				continue
+			}
+
+			st, ok := byHash[infos[0].ChunkID]
+			if !ok {
+				st = &State{Hash: infos[0].ChunkID, Info: infos[0]}
+				byHash[st.Hash] = st
+			}
+			if target > st.Target {
+				st.Target = target
+			}
+			st.Refs = append(st.Refs, metastore.ChunkRef{FileID: meta.FileID, Index: index})
+
+			for _, ci := range infos {
+				st.AddNode(ci.NodeURL)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]*State, 0, len(byHash))
+	for _, st := range byHash {
+		states = append(states, st)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Hash < states[j].Hash })
+
+	return states, nil
+}
+
+// AddNode добавляет сервер с копией чанка, если его еще нет в списке
+func (st *State) AddNode(node string) {
+	if node == "" {
+		return
+	}
+	for _, v := range st.Nodes {
+		if v == node {
+			return
+		}
+	}
+	st.Nodes = append(st.Nodes, node)
+}
@@ -0,0 +1,542 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/placement"
+)
+
+// State состояние сервера хранения
+type State string
+
+const (
+	StateUp      State = "up"      // Сервер отвечает
+	StateSuspect State = "suspect" // Последние проверки неудачны, сервер еще используется
+	StateDown    State = "down"    // Сервер недоступен и пропускается
+)
+
+// Способы добавления сервера в реестр
+const (
+	SourceStatic    = "static"    // Из параметров запуска
+	SourceAdmin     = "admin"     // Через админ API
+	SourceHeartbeat = "heartbeat" // Сервер зарегистрировался сам
+)
+
+// Состояния вывода сервера из эксплуатации
+const (
+	DrainActive = "draining" // Данные переносятся, новые чанки не размещаются
+	DrainDone   = "drained"  // На сервере не осталось нужных данных, его можно удалить
+)
+
+// ErrNodeNotFound возвращается, если сервер не зарегистрирован
+var ErrNodeNotFound = errors.New("node not found")
+
+// NodeStatus ответ сервера хранения на /status
+type NodeStatus struct {
+	NodeID    string `json:"nodeID"`
+	Status    string `json:"status"`
+	Chunks    int    `json:"chunks"`
+	TotalSize int64  `json:"totalSize"`
+	FreeSpace int64  `json:"freeSpace"`
+	Capacity  int64  `json:"capacity"`
+
+	placement.Domain // Расположение сервера: зона, стойка, хост
+
+	Scrub *ScrubStatus `json:"scrub,omitempty"` // Проверка целостности чанков на сервере
+}
+
+// ScrubStatus состояние фоновой проверки целостности чанков на сервере
+type ScrubStatus struct {
+	Running      bool      `json:"running"`
+	Passes       int       `json:"passes"`
+	LastStart    time.Time `json:"lastStart"`
+	LastFinish   time.Time `json:"lastFinish"`
+	Checked      int       `json:"checked"` // Проверено чанков в текущем или последнем проходе
+	BytesChecked int64     `json:"bytesChecked"`
+	Quarantined  int       `json:"quarantined"` // Всего чанков в карантине
+
+	Chunks []QuarantinedChunk `json:"chunks,omitempty"` // Последние чанки, попавшие в карантин
+}
+
+// QuarantinedChunk поврежденный или нечитаемый чанк, перенесенный в карантин
+type QuarantinedChunk struct {
+	ID         string    `json:"id"`
+	Size       int64     `json:"size"`
+	ActualHash string    `json:"actualHash,omitempty"` // Хеш фактического содержимого
+	ReadError  string    `json:"readError,omitempty"`  // Ошибка чтения, если чанк не удалось прочитать
+	DetectedAt time.Time `json:"detectedAt"`
+}
+
+// Node состояние сервера хранения в реестре
+type Node struct {
+	URL           string     `json:"url"`
+	Source        string     `json:"source"`
+	State         State      `json:"state"`
+	Drain         string     `json:"drain,omitempty"`
+	Status        NodeStatus `json:"status"`
+	LastSeen      time.Time  `json:"lastSeen"`
+	LastCheck     time.Time  `json:"lastCheck"`
+	LastHeartbeat time.Time  `json:"lastHeartbeat"`
+	LastError     string     `json:"lastError,omitempty"`
+	Failures      int        `json:"failures"`  // Неудачные проверки подряд
+	Successes     int        `json:"successes"` // Удачные проверки подряд
+	AddedAt       time.Time  `json:"addedAt"`
+	StateSince    time.Time  `json:"stateSince"`
+}
+
+// Registry реестр серверов хранения. Периодически опрашивает /status каждого
+// сервера и переводит его между состояниями с гистерезисом: один сбой делает
+// сервер подозрительным, DownAfter сбоев подряд - недоступным, а вернуться
+// из недоступных он может только после UpAfter удачных проверок подряд.
+//
+// Серверы, добавленные через админ API или по heartbeat, сохраняются в Store
+// и восстанавливаются после перезапуска. Сервер, зарегистрировавшийся сам,
+// удаляется из реестра, если от него нет heartbeat дольше HeartbeatTTL.
+type Registry struct {
+	Store        metastore.MetaStore // Хранилище добавленных серверов (может быть nil)
+	Interval     time.Duration       // Период между проверками
+	Timeout      time.Duration       // Время ожидания ответа на проверку
+	DownAfter    int                 // Сбоев подряд до перевода в down
+	UpAfter      int                 // Удачных проверок подряд до возврата из down
+	HeartbeatTTL time.Duration       // Время без heartbeat до удаления сервера
+	HighWater    float64             // Доля заполнения диска, после которой на сервер не пишут
+
+	client  *http.Client
+	mu      sync.RWMutex
+	nodes   map[string]*Node
+	order   []string // Порядок добавления серверов
+	trigger chan struct{}
+}
+
+// New создает реестр с начальным списком серверов
+func New(urls []string) *Registry {
+	r := &Registry{
+		Interval:     5 * time.Second,
+		Timeout:      2 * time.Second,
+		DownAfter:    3,
+		UpAfter:      2,
+		HeartbeatTTL: 30 * time.Second,
+		HighWater:    0.9,
+		client:       &http.Client{},
+		nodes:        make(map[string]*Node),
+		trigger:      make(chan struct{}, 1),
+	}
+	for _, url := range urls {
+		r.Add(url)
+	}
+	return r
+}
+
+// Load добавляет в реестр серверы, сохраненные в Store. Отсчет HeartbeatTTL
+// для самостоятельно зарегистрировавшихся серверов начинается заново.
+func (r *Registry) Load() error {
+	if r.Store == nil {
+		return nil
+	}
+
+	var records []metastore.NodeRecord
+	err := r.Store.ForEachNode(func(node metastore.NodeRecord) error {
+		records = append(records, node)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		// Серверы из параметров запуска сохраняются только ради состояния
+		// вывода из эксплуатации и не добавляются, если их убрали из параметров
+		if rec.Source == SourceStatic {
+			r.mu.Lock()
+			if n, ok := r.nodes[rec.URL]; ok {
+				n.Drain = rec.Drain
+			}
+			r.mu.Unlock()
+			continue
+		}
+
+		if n, ok := r.add(rec.URL, rec.Source); ok {
+			r.mu.Lock()
+			n.AddedAt = rec.RegisteredAt
+			n.Status.NodeID = rec.NodeID
+			n.Drain = rec.Drain
+			if rec.Source == SourceHeartbeat {
+				n.LastHeartbeat = time.Now()
+			}
+			r.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// Add добавляет сервер из параметров запуска. Новый сервер считается
+// доступным до первой проверки. Возвращает false, если сервер уже
+// зарегистрирован.
+func (r *Registry) Add(url string) bool {
+	_, ok := r.add(url, SourceStatic)
+	return ok
+}
+
+// Register добавляет сервер во время работы и сохраняет его в Store.
+// Возвращает false, если сервер уже зарегистрирован.
+func (r *Registry) Register(url, source string) (bool, error) {
+	n, ok := r.add(url, source)
+	if !ok {
+		return false, nil
+	}
+	return true, r.persist(n)
+}
+
+// Heartbeat учитывает heartbeat сервера с содержимым его /status.
+// Незнакомый сервер регистрируется.
+func (r *Registry) Heartbeat(url string, status NodeStatus) error {
+	n, added := r.add(url, SourceHeartbeat)
+	if n == nil {
+		return fmt.Errorf("invalid node url: %q", url)
+	}
+
+	r.mu.Lock()
+	n.LastHeartbeat = time.Now()
+	r.mu.Unlock()
+
+	r.record(n.URL, status, nil)
+	if added {
+		log.Printf("Storage node %s registered", n.URL)
+		return r.persist(n)
+	}
+	return nil
+}
+
+// Remove удаляет сервер из реестра по URL или ID сервера. Серверы из
+// параметров запуска вернутся в реестр после перезапуска.
+func (r *Registry) Remove(id string) (Node, error) {
+	r.mu.Lock()
+	n := r.find(id)
+	if n == nil {
+		r.mu.Unlock()
+		return Node{}, ErrNodeNotFound
+	}
+	r.remove(n.URL)
+	node := *n
+	r.mu.Unlock()
+
+	if r.Store != nil {
+		if err := r.Store.DeleteNode(node.URL); err != nil {
+			return node, err
+		}
+	}
+	return node, nil
+}
+
+// Get возвращает сервер по URL или ID сервера
+func (r *Registry) Get(id string) (Node, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	n := r.find(id)
+	if n == nil {
+		return Node{}, ErrNodeNotFound
+	}
+	return *n, nil
+}
+
+// Nodes возвращает все серверы в порядке добавления
+func (r *Registry) Nodes() []Node {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]Node, 0, len(r.order))
+	for _, url := range r.order {
+		result = append(result, *r.nodes[url])
+	}
+	return result
+}
+
+// Available возвращает URL серверов, на которые можно размещать чанки:
+// не находящихся в состоянии down и не выводимых из эксплуатации
+func (r *Registry) Available() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]string, 0, len(r.order))
+	for _, url := range r.order {
+		if n := r.nodes[url]; n.State != StateDown && n.Drain == "" {
+			result = append(result, url)
+		}
+	}
+	return result
+}
+
+// Weights возвращает веса серверов для размещения: количество байт,
+// которое можно записать до достижения HighWater. Серверы без сведений
+// о емкости в результат не входят.
+func (r *Registry) Weights() map[string]float64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make(map[string]float64, len(r.nodes))
+	for url, n := range r.nodes {
+		capacity := n.Status.Capacity
+		if capacity == 0 {
+			// Старые серверы не сообщают емкость диска
+			capacity = n.Status.FreeSpace + n.Status.TotalSize
+		}
+		if capacity <= 0 {
+			continue
+		}
+
+		used := capacity - n.Status.FreeSpace
+		if headroom := r.HighWater*float64(capacity) - float64(used); headroom > 0 {
+			result[url] = headroom
+		} else {
+			result[url] = 0
+		}
+	}
+	return result
+}
+
+// SetDrain изменяет состояние вывода сервера из эксплуатации и сохраняет его
+// в Store. Пустое состояние возвращает сервер в работу.
+func (r *Registry) SetDrain(id, drain string) (Node, error) {
+	r.mu.Lock()
+	n := r.find(id)
+	if n == nil {
+		r.mu.Unlock()
+		return Node{}, ErrNodeNotFound
+	}
+	n.Drain = drain
+	node := *n
+	r.mu.Unlock()
+
+	return node, r.persist(n)
+}
+
+// Domains возвращает расположение серверов по их меткам из /status
+func (r *Registry) Domains() map[string]placement.Domain {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make(map[string]placement.Domain, len(r.nodes))
+	for url, n := range r.nodes {
+		result[url] = n.Status.Domain
+	}
+	return result
+}
+
+// IsDown сообщает, что сервер зарегистрирован и недоступен
+func (r *Registry) IsDown(url string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	n, ok := r.nodes[url]
+	return ok && n.State == StateDown
+}
+
+// Run запускает периодические проверки серверов до отмены контекста
+func (r *Registry) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.CheckAll(ctx)
+		r.expire(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.trigger:
+		}
+	}
+}
+
+// Trigger запрашивает внеочередную проверку серверов
+func (r *Registry) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// CheckAll параллельно проверяет все зарегистрированные серверы
+func (r *Registry) CheckAll(ctx context.Context) {
+	r.mu.RLock()
+	urls := append([]string(nil), r.order...)
+	r.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, url := range urls {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			status, err := r.probe(ctx, url)
+			r.record(url, status, err)
+		}(url)
+	}
+	wg.Wait()
+}
+
+// probe запрашивает /status сервера
+func (r *Registry) probe(ctx context.Context, url string) (NodeStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/status", nil)
+	if err != nil {
+		return NodeStatus{}, err
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return NodeStatus{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return NodeStatus{}, fmt.Errorf("status check failed: %d", resp.StatusCode)
+	}
+
+	var status NodeStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return NodeStatus{}, fmt.Errorf("invalid status response: %w", err)
+	}
+	return status, nil
+}
+
+// record учитывает результат проверки и обновляет состояние сервера
+func (r *Registry) record(url string, status NodeStatus, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[url]
+	if !ok {
+		// Сервер удален во время проверки
+		return
+	}
+
+	now := time.Now()
+	n.LastCheck = now
+	prev := n.State
+
+	if err != nil {
+		n.LastError = err.Error()
+		n.Failures++
+		n.Successes = 0
+		switch {
+		case n.Failures >= r.DownAfter:
+			n.State = StateDown
+		case n.State == StateUp:
+			n.State = StateSuspect
+		}
+	} else {
+		n.Status = status
+		n.LastSeen = now
+		n.LastError = ""
+		n.Failures = 0
+		n.Successes++
+		if n.State != StateDown || n.Successes >= r.UpAfter {
+			n.State = StateUp
+		}
+	}
+
+	if n.State != prev {
+		n.StateSince = now
+		log.Printf("Storage node %s is %s", url, n.State)
+	}
+}
+
+// add добавляет сервер в реестр. Возвращает false и существующий сервер,
+// если он уже зарегистрирован.
+func (r *Registry) add(url, source string) (*Node, bool) {
+	url = strings.TrimRight(strings.TrimSpace(url), "/")
+	if url == "" {
+		return nil, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n, ok := r.nodes[url]; ok {
+		return n, false
+	}
+	now := time.Now()
+	n := &Node{URL: url, Source: source, State: StateUp, AddedAt: now, StateSince: now}
+	r.nodes[url] = n
+	r.order = append(r.order, url)
+	r.Trigger()
+	return n, true
+}
+
+// remove удаляет сервер из реестра. Вызывается под r.mu.
+func (r *Registry) remove(url string) {
+	delete(r.nodes, url)
+	for i, u := range r.order {
+		if u == url {
+			r.order = append(r.order[:i:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// persist сохраняет сервер в Store
+func (r *Registry) persist(n *Node) error {
+	if r.Store == nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	rec := metastore.NodeRecord{URL: n.URL, NodeID: n.Status.NodeID, Source: n.Source, RegisteredAt: n.AddedAt, Drain: n.Drain}
+	r.mu.RUnlock()
+
+	return r.Store.SaveNode(rec)
+}
+
+// expire удаляет самостоятельно зарегистрировавшиеся серверы,
+// от которых давно не было heartbeat
+func (r *Registry) expire(now time.Time) {
+	if r.HeartbeatTTL <= 0 {
+		return
+	}
+
+	var expired []string
+	r.mu.Lock()
+	for _, url := range append([]string(nil), r.order...) {
+		n := r.nodes[url]
+		if n.Source == SourceHeartbeat && now.Sub(n.LastHeartbeat) > r.HeartbeatTTL {
+			r.remove(url)
+			expired = append(expired, url)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, url := range expired {
+		log.Printf("Storage node %s expired: no heartbeat for %s", url, r.HeartbeatTTL)
+		if r.Store != nil {
+			if err := r.Store.DeleteNode(url); err != nil {
+				log.Printf("Failed to delete node %s: %v", url, err)
+			}
+		}
+	}
+}
+
+// find ищет сервер по URL или ID сервера
+func (r *Registry) find(id string) *Node {
+	if n, ok := r.nodes[strings.TrimRight(id, "/")]; ok {
+		return n
+	}
+	for _, url := range r.order {
+		if n := r.nodes[url]; n.Status.NodeID == id {
+			return n
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+)
+
+// openTestStore открывает хранилище метаданных во временной директории теста
+func openTestStore(t *testing.T) *metastore.BoltStore {
+	t.Helper()
+
+	store, err := metastore.NewBoltStore(filepath.Join(t.TempDir(), "meta.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// nodeURLs возвращает URL серверов реестра по порядку добавления
+func nodeURLs(r *Registry) []string {
+	var urls []string
+	for _, n := range r.Nodes() {
+		urls = append(urls, n.URL)
+	}
+	return urls
+}
+
+func TestRecordHysteresis(t *testing.T) {
+	const url = "http://node-1"
+	r := New([]string{url})
+	errProbe := errors.New("connection refused")
+
+	steps := []struct {
+		err  error
+		want State
+	}{
+		{errProbe, StateSuspect},
+		{errProbe, StateSuspect},
+		{errProbe, StateDown}, // DownAfter = 3
+		{nil, StateDown},      // UpAfter = 2
+		{errProbe, StateDown},
+		{nil, StateDown},
+		{nil, StateUp},
+		{errProbe, StateSuspect},
+		{nil, StateUp}, // Из suspect сервер возвращается сразу
+	}
+	for i, step := range steps {
+		r.record(url, NodeStatus{}, step.err)
+		n, err := r.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.State != step.want {
+			t.Fatalf("step %d: state = %s, want %s", i, n.State, step.want)
+		}
+		if down := r.IsDown(url); down != (step.want == StateDown) {
+			t.Fatalf("step %d: IsDown = %v", i, down)
+		}
+		if available := slices.Contains(r.Available(), url); available != (step.want != StateDown) {
+			t.Fatalf("step %d: available = %v", i, available)
+		}
+	}
+}
+
+func TestCheckAllProbesStatus(t *testing.T) {
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"nodeID":"node-1","status":"ok","freeSpace":100,"capacity":200}`))
+	}))
+	defer srv.Close()
+
+	r := New([]string{srv.URL})
+	r.DownAfter, r.UpAfter = 1, 1
+
+	r.CheckAll(context.Background())
+	if !r.IsDown(srv.URL) {
+		t.Fatal("node with failing /status is not down")
+	}
+
+	healthy.Store(true)
+	r.CheckAll(context.Background())
+	n, err := r.Get("node-1")
+	if err != nil {
+		t.Fatalf("node not found by its ID: %v", err)
+	}
+	if n.State != StateUp || n.Status.FreeSpace != 100 {
+		t.Fatalf("node = %+v, want up with reported status", n)
+	}
+}
+
+func TestHeartbeatExpiry(t *testing.T) {
+	store := openTestStore(t)
+	r := New([]string{"http://static"})
+	r.Store = store
+	r.HeartbeatTTL = time.Minute
+
+	if err := r.Heartbeat("http://self/", NodeStatus{NodeID: "self"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeURLs(r); !slices.Equal(got, []string{"http://static", "http://self"}) {
+		t.Fatalf("nodes = %v", got)
+	}
+
+	// Heartbeat в пределах TTL сохраняет сервер
+	r.expire(time.Now().Add(30 * time.Second))
+	if _, err := r.Get("http://self"); err != nil {
+		t.Fatalf("node expired before TTL: %v", err)
+	}
+
+	// Без heartbeat дольше TTL удаляется только сервер, зарегистрировавшийся сам
+	r.expire(time.Now().Add(2 * time.Minute))
+	if got := nodeURLs(r); !slices.Equal(got, []string{"http://static"}) {
+		t.Fatalf("nodes after expiry = %v, want only the static node", got)
+	}
+	store.ForEachNode(func(node metastore.NodeRecord) error {
+		t.Errorf("expired node %s is still stored", node.URL)
+		return nil
+	})
+}
+
+func TestLoadRestoresNodes(t *testing.T) {
+	store := openTestStore(t)
+
+	r := New([]string{"http://static-1", "http://static-2"})
+	r.Store = store
+	if _, err := r.Register("http://admin", SourceAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Heartbeat("http://self", NodeStatus{NodeID: "self"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.SetDrain("http://static-1", DrainActive); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.SetDrain("http://static-2", DrainActive); err != nil {
+		t.Fatal(err)
+	}
+
+	// После перезапуска static-2 убран из параметров запуска
+	restarted := New([]string{"http://static-1"})
+	restarted.Store = store
+	if err := restarted.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := nodeURLs(restarted)
+	slices.Sort(got)
+	if want := []string{"http://admin", "http://self", "http://static-1"}; !slices.Equal(got, want) {
+		t.Fatalf("nodes after Load = %v, want %v", got, want)
+	}
+
+	n, _ := restarted.Get("http://static-1")
+	if n.Drain != DrainActive {
+		t.Errorf("drain of static node = %q, want %q", n.Drain, DrainActive)
+	}
+	n, _ = restarted.Get("self")
+	if n.Source != SourceHeartbeat || n.LastHeartbeat.IsZero() {
+		t.Errorf("self-registered node = %+v, want heartbeat source with fresh heartbeat", n)
+	}
+	if slices.Contains(restarted.Available(), "http://static-1") {
+		t.Error("draining node is available for placement")
+	}
+}
@@ -0,0 +1,144 @@
+package erasure
+
+// Арифметика в поле Галуа GF(2^8) с порождающим многочленом x^8+x^4+x^3+x^2+1 (0x11d)
+
+var (
+	expTable [510]byte
+	logTable [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+// gfMul умножает два элемента поля
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+// gfInv возвращает обратный элемент (a != 0)
+func gfInv(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
+
+// gfExp возводит a в степень n
+func gfExp(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])*n)%255]
+}
+
+// matrix матрица над GF(2^8)
+type matrix [][]byte
+
+// newMatrix создает нулевую матрицу rows x cols
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+// vandermonde создает матрицу Вандермонда rows x cols
+func vandermonde(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			m[r][c] = gfExp(byte(r), c)
+		}
+	}
+	return m
+}
+
+// mul умножает матрицу m на матрицу o
+func (m matrix) mul(o matrix) matrix {
+	res := newMatrix(len(m), len(o[0]))
+	for r := range m {
+		for c := range o[0] {
+			var v byte
+			for i := range o {
+				v ^= gfMul(m[r][i], o[i][c])
+			}
+			res[r][c] = v
+		}
+	}
+	return res
+}
+
+// invert возвращает обратную квадратную матрицу методом Гаусса-Жордана
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+	work := newMatrix(n, 2*n)
+	for r := 0; r < n; r++ {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		// Ищем строку с ненулевым ведущим элементом
+		pivot := -1
+		for r := c; r < n; r++ {
+			if work[r][c] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, ErrSingularMatrix
+		}
+		work[c], work[pivot] = work[pivot], work[c]
+
+		// Нормируем строку
+		inv := gfInv(work[c][c])
+		for i := range work[c] {
+			work[c][i] = gfMul(work[c][i], inv)
+		}
+
+		// Обнуляем столбец в остальных строках
+		for r := 0; r < n; r++ {
+			if r == c || work[r][c] == 0 {
+				continue
+			}
+			f := work[r][c]
+			for i := range work[r] {
+				work[r][i] ^= gfMul(f, work[c][i])
+			}
+		}
+	}
+
+	res := newMatrix(n, n)
+	for r := 0; r < n; r++ {
+		copy(res[r], work[r][n:])
+	}
+	return res, nil
+}
+
+// mulSliceXor вычисляет out ^= c * in побайтово
+func mulSliceXor(c byte, in, out []byte) {
+	if c == 0 {
+		return
+	}
+	logC := int(logTable[c])
+	for i, v := range in {
+		if v != 0 {
+			out[i] ^= expTable[logC+int(logTable[v])]
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package erasure
+
+import "testing"
+
+func TestGFInverse(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if got := gfMul(byte(a), gfInv(byte(a))); got != 1 {
+			t.Fatalf("%d * inv(%d) = %d, want 1", a, a, got)
+		}
+	}
+}
+
+func TestGFMulDistributes(t *testing.T) {
+	for a := 0; a < 256; a++ {
+		for b := 0; b < 256; b += 7 {
+			for c := 0; c < 256; c += 13 {
+				x, y, z := byte(a), byte(b), byte(c)
+				if gfMul(x, y^z) != gfMul(x, y)^gfMul(x, z) {
+					t.Fatalf("%d*(%d^%d) is not distributive", a, b, c)
+				}
+			}
+		}
+	}
+}
+
+func TestMatrixInvert(t *testing.T) {
+	m := vandermonde(5, 5)
+	inv, err := m.invert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := m.mul(inv)
+	for r := range id {
+		for c := range id[r] {
+			want := byte(0)
+			if r == c {
+				want = 1
+			}
+			if id[r][c] != want {
+				t.Fatalf("m * inv(m) [%d][%d] = %d, want %d", r, c, id[r][c], want)
+			}
+		}
+	}
+
+	singular := matrix{{1, 2}, {1, 2}}
+	if _, err := singular.invert(); err != ErrSingularMatrix {
+		t.Fatalf("invert of singular matrix: err = %v, want %v", err, ErrSingularMatrix)
+	}
+}
@@ -0,0 +1,199 @@
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrSingularMatrix возвращается, если матрица декодирования необратима
+	ErrSingularMatrix = errors.New("erasure: singular matrix")
+
+	// ErrTooFewShards возвращается, если для восстановления не хватает шардов
+	ErrTooFewShards = errors.New("erasure: too few shards")
+
+	// ErrShardSize возвращается, если шарды имеют разный размер
+	ErrShardSize = errors.New("erasure: shard sizes do not match")
+)
+
+// Encoder систематический код Рида-Соломона: первые DataShards шардов содержат
+// исходные данные, остальные ParityShards - четность. Данные восстанавливаются
+// по любым DataShards шардам.
+type Encoder struct {
+	DataShards   int
+	ParityShards int
+	matrix       matrix // (DataShards+ParityShards) x DataShards
+}
+
+// New создает кодировщик для k шардов данных и m шардов четности
+func New(dataShards, parityShards int) (*Encoder, error) {
+	if dataShards <= 0 || parityShards < 0 {
+		return nil, fmt.Errorf("erasure: invalid shard counts %d+%d", dataShards, parityShards)
+	}
+	if dataShards+parityShards > 256 {
+		return nil, fmt.Errorf("erasure: too many shards %d+%d", dataShards, parityShards)
+	}
+
+	// Приводим матрицу Вандермонда к систематическому виду:
+	// верхний квадрат становится единичной матрицей
+	total := dataShards + parityShards
+	v := vandermonde(total, dataShards)
+	top, err := v[:dataShards].invert()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Encoder{
+		DataShards:   dataShards,
+		ParityShards: parityShards,
+		matrix:       v.mul(top),
+	}, nil
+}
+
+// Split разбивает данные на DataShards шардов одинакового размера
+// (последний дополняется нулями) и выделяет место под шарды четности
+func (e *Encoder) Split(data []byte) [][]byte {
+	shardSize := (len(data) + e.DataShards - 1) / e.DataShards
+	if shardSize == 0 {
+		shardSize = 1
+	}
+
+	total := e.DataShards + e.ParityShards
+	buf := make([]byte, shardSize*total)
+	copy(buf, data)
+
+	shards := make([][]byte, total)
+	for i := range shards {
+		shards[i] = buf[i*shardSize : (i+1)*shardSize : (i+1)*shardSize]
+	}
+	return shards
+}
+
+// Encode вычисляет шарды четности по шардам данных
+func (e *Encoder) Encode(shards [][]byte) error {
+	if len(shards) != e.DataShards+e.ParityShards {
+		return ErrTooFewShards
+	}
+	size, err := shardSize(shards)
+	if err != nil {
+		return err
+	}
+
+	for p := 0; p < e.ParityShards; p++ {
+		out := shards[e.DataShards+p]
+		if len(out) != size {
+			return ErrShardSize
+		}
+		clear(out)
+		for d := 0; d < e.DataShards; d++ {
+			mulSliceXor(e.matrix[e.DataShards+p][d], shards[d], out)
+		}
+	}
+	return nil
+}
+
+// Reconstruct восстанавливает отсутствующие шарды (nil) на месте.
+// Требуется хотя бы DataShards присутствующих шардов.
+func (e *Encoder) Reconstruct(shards [][]byte) error {
+	total := e.DataShards + e.ParityShards
+	if len(shards) != total {
+		return ErrTooFewShards
+	}
+	size, err := shardSize(shards)
+	if err != nil {
+		return err
+	}
+
+	// Выбираем первые DataShards доступных шардов
+	rows := make([]int, 0, e.DataShards)
+	missingData := false
+	for i, s := range shards {
+		if s == nil {
+			if i < e.DataShards {
+				missingData = true
+			}
+			continue
+		}
+		if len(rows) < e.DataShards {
+			rows = append(rows, i)
+		}
+	}
+	if len(rows) < e.DataShards {
+		return ErrTooFewShards
+	}
+
+	if missingData {
+		sub := newMatrix(e.DataShards, e.DataShards)
+		for i, r := range rows {
+			copy(sub[i], e.matrix[r])
+		}
+		dec, err := sub.invert()
+		if err != nil {
+			return err
+		}
+
+		for d := 0; d < e.DataShards; d++ {
+			if shards[d] != nil {
+				continue
+			}
+			out := make([]byte, size)
+			for i, r := range rows {
+				mulSliceXor(dec[d][i], shards[r], out)
+			}
+			shards[d] = out
+		}
+	}
+
+	// Пересчитываем отсутствующие шарды четности по полным данным
+	for p := 0; p < e.ParityShards; p++ {
+		idx := e.DataShards + p
+		if shards[idx] != nil {
+			continue
+		}
+		out := make([]byte, size)
+		for d := 0; d < e.DataShards; d++ {
+			mulSliceXor(e.matrix[idx][d], shards[d], out)
+		}
+		shards[idx] = out
+	}
+
+	return nil
+}
+
+// Join склеивает шарды данных и обрезает результат до size байт
+func (e *Encoder) Join(shards [][]byte, size int) ([]byte, error) {
+	if len(shards) < e.DataShards {
+		return nil, ErrTooFewShards
+	}
+
+	data := make([]byte, 0, size)
+	for d := 0; d < e.DataShards && len(data) < size; d++ {
+		if shards[d] == nil {
+			return nil, ErrTooFewShards
+		}
+		data = append(data, shards[d]...)
+	}
+	if len(data) < size {
+		return nil, ErrShardSize
+	}
+	return data[:size], nil
+}
+
+// shardSize возвращает общий размер присутствующих шардов
+func shardSize(shards [][]byte) (int, error) {
+	size := -1
+	for _, s := range shards {
+		if s == nil {
+			continue
+		}
+		if size < 0 {
+			size = len(s)
+		} else if len(s) != size {
+			return 0, ErrShardSize
+		}
+	}
+	if size <= 0 {
+		return 0, ErrTooFewShards
+	}
+	return size, nil
+}
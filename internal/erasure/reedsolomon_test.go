@@ -0,0 +1,143 @@
+package erasure
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/Gammanik/distributed-storage/internal/chunker"
+)
+
+// erasures возвращает все наборы номеров шардов из total размером от 1 до m
+func erasures(total, m int) [][]int {
+	var result [][]int
+	var walk func(start int, set []int)
+	walk = func(start int, set []int) {
+		if len(set) > 0 {
+			result = append(result, append([]int(nil), set...))
+		}
+		if len(set) == m {
+			return
+		}
+		for i := start; i < total; i++ {
+			walk(i+1, append(set, i))
+		}
+	}
+	walk(0, nil)
+	return result
+}
+
+// roundTrip кодирует data, для каждого набора из не более m шардов стирает
+// их, восстанавливает и сверяет результат с исходными данными
+func roundTrip(t *testing.T, enc *Encoder, data []byte) {
+	t.Helper()
+	shards := enc.Split(data)
+	if err := enc.Encode(shards); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, erased := range erasures(len(shards), enc.ParityShards) {
+		damaged := make([][]byte, len(shards))
+		for i, s := range shards {
+			damaged[i] = bytes.Clone(s)
+		}
+		for _, i := range erased {
+			damaged[i] = nil
+		}
+
+		if err := enc.Reconstruct(damaged); err != nil {
+			t.Fatalf("erased %v: %v", erased, err)
+		}
+		for i := range shards {
+			if !bytes.Equal(damaged[i], shards[i]) {
+				t.Fatalf("erased %v: shard %d differs after reconstruction", erased, i)
+			}
+		}
+		got, err := enc.Join(damaged, len(data))
+		if err != nil {
+			t.Fatalf("erased %v: %v", erased, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("erased %v: data differs after reconstruction", erased)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	configs := []struct{ k, m int }{{1, 1}, {2, 1}, {3, 2}, {4, 2}, {5, 3}, {6, 0}}
+	sizes := []int{1, 2, 3, 5, 7, 64, 1000, 4099}
+
+	for _, c := range configs {
+		enc, err := New(c.k, c.m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range sizes {
+			t.Run(fmt.Sprintf("%d+%d/%d bytes", c.k, c.m, size), func(t *testing.T) {
+				data := make([]byte, size)
+				rnd.Read(data)
+				roundTrip(t, enc, data)
+			})
+		}
+	}
+}
+
+func TestRoundTripFileChunks(t *testing.T) {
+	enc, err := New(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Последний чанк файла короче остальных и не делится на число шардов
+	data := make([]byte, 3*1000+13)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	cr := chunker.NewChunkReader(bytes.NewReader(data), 1000)
+	defer cr.Close()
+
+	var sizes []int
+	for {
+		chunk, _, err := cr.NextChunk()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, len(chunk))
+		roundTrip(t, enc, bytes.Clone(chunk))
+	}
+	if fmt.Sprint(sizes) != "[1000 1000 1000 13]" {
+		t.Fatalf("chunk sizes = %v", sizes)
+	}
+}
+
+func TestReconstructTooFewShards(t *testing.T) {
+	enc, err := New(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := enc.Split([]byte("not enough shards"))
+	if err := enc.Encode(shards); err != nil {
+		t.Fatal(err)
+	}
+	shards[0], shards[2], shards[5] = nil, nil, nil
+	if err := enc.Reconstruct(shards); err != ErrTooFewShards {
+		t.Fatalf("err = %v, want %v", err, ErrTooFewShards)
+	}
+}
+
+func TestReconstructShardSize(t *testing.T) {
+	enc, err := New(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := [][]byte{{1, 2}, {3}, nil}
+	if err := enc.Reconstruct(shards); err != ErrShardSize {
+		t.Fatalf("err = %v, want %v", err, ErrShardSize)
+	}
+}
@@ -0,0 +1,40 @@
+package erasure
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+// ErrShardHeader возвращается, если заголовок шарда относится к другому
+// чанку или другому номеру шарда
+var ErrShardHeader = errors.New("erasure: shard header does not match")
+
+// shardMagic начало заголовка шарда
+const shardMagic = "rs1:"
+
+// WrapShard добавляет к шарду заголовок с хешем чанка и номером шарда.
+// Шарды хранятся на серверах под хешем содержимого, а у разных чанков
+// бывают одинаковые шарды (например, нулевое дополнение маленьких чанков).
+// Заголовок делает содержимое и хеш каждого шарда уникальными для чанка,
+// поэтому удаление шарда одного чанка не затрагивает другой.
+func WrapShard(chunkHash string, index int, shard []byte) []byte {
+	header := shardHeader(chunkHash, index)
+	data := make([]byte, 0, len(header)+len(shard))
+	data = append(data, header...)
+	return append(data, shard...)
+}
+
+// UnwrapShard снимает заголовок, добавленный WrapShard
+func UnwrapShard(chunkHash string, index int, data []byte) ([]byte, error) {
+	header := shardHeader(chunkHash, index)
+	if !bytes.HasPrefix(data, header) {
+		return nil, ErrShardHeader
+	}
+	return data[len(header):], nil
+}
+
+// shardHeader возвращает заголовок шарда: "rs1:<хеш чанка>:<номер>\n"
+func shardHeader(chunkHash string, index int) []byte {
+	return []byte(shardMagic + chunkHash + ":" + strconv.Itoa(index) + "\n")
+}
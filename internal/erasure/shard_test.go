@@ -0,0 +1,43 @@
+package erasure
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestShardHeader(t *testing.T) {
+	shard := []byte("shard data")
+	wrapped := WrapShard("abc", 3, shard)
+
+	got, err := UnwrapShard("abc", 3, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, shard) {
+		t.Fatalf("unwrapped = %q, want %q", got, shard)
+	}
+
+	// Одинаковые шарды разных чанков хранятся под разными хешами
+	if bytes.Equal(wrapped, WrapShard("abd", 3, shard)) {
+		t.Fatal("shards of different chunks have identical content")
+	}
+
+	tests := []struct {
+		name  string
+		hash  string
+		index int
+		data  []byte
+	}{
+		{"other chunk", "abd", 3, wrapped},
+		{"other index", "abc", 4, wrapped},
+		{"no header", "abc", 3, shard},
+		{"truncated header", "abc", 3, wrapped[:5]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := UnwrapShard(tt.hash, tt.index, tt.data); err != ErrShardHeader {
+				t.Fatalf("err = %v, want %v", err, ErrShardHeader)
+			}
+		})
+	}
+}
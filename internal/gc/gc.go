@@ -0,0 +1,194 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/storage"
+)
+
+// Status содержит информацию о работе сборщика мусора
+type Status struct {
+	Running    bool      `json:"running"`
+	Passes     int       `json:"passes"`
+	LastStart  time.Time `json:"lastStart"`
+	LastFinish time.Time `json:"lastFinish"`
+	Pending    int       `json:"pending"`
+	Deleted    int       `json:"deleted"`
+	Revived    int       `json:"revived"`
+	Failed     int       `json:"failed"`
+	LastError  string    `json:"lastError,omitempty"`
+}
+
+// Collector удаляет с серверов хранения чанки, на которые больше
+// не ссылается ни один файл
+type Collector struct {
+	Store    metastore.MetaStore
+	Storage  storage.Client
+	Interval time.Duration // Период между проходами
+
+	mu      sync.Mutex
+	status  Status
+	trigger chan struct{}
+}
+
+// New создает новый Collector
+func New(store metastore.MetaStore, client storage.Client) *Collector {
+	return &Collector{
+		Store:    store,
+		Storage:  client,
+		Interval: time.Minute,
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// Run запускает периодические проходы сборки мусора до отмены контекста.
+// Если Interval <= 0, проходы выполняются только по Trigger.
+func (c *Collector) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if c.Interval > 0 {
+		ticker := time.NewTicker(c.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-c.trigger:
+		}
+
+		if err := c.RunOnce(ctx); err != nil {
+			log.Printf("GC pass failed: %v", err)
+		}
+	}
+}
+
+// Trigger запрашивает внеочередной проход сборки мусора
+func (c *Collector) Trigger() {
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Status возвращает текущее состояние сборщика мусора
+func (c *Collector) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// RunOnce выполняет один проход сборки мусора
+func (c *Collector) RunOnce(ctx context.Context) error {
+	c.mu.Lock()
+	if c.status.Running {
+		c.mu.Unlock()
+		return fmt.Errorf("gc pass already running")
+	}
+	c.status = Status{Running: true, Passes: c.status.Passes, LastStart: time.Now()}
+	c.mu.Unlock()
+
+	err := c.collect(ctx)
+
+	c.update(func(s *Status) {
+		s.Running = false
+		s.Passes++
+		s.LastFinish = time.Now()
+		if err != nil {
+			s.LastError = err.Error()
+		}
+	})
+
+	return err
+}
+
+// update изменяет статус под мьютексом
+func (c *Collector) update(fn func(s *Status)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn(&c.status)
+}
+
+// collect удаляет все чанки из очереди на удаление
+func (c *Collector) collect(ctx context.Context) error {
+	garbage := make(map[string][]metastore.ChunkInfo)
+	err := c.Store.ForEachGarbage(func(hash string, locations []metastore.ChunkInfo) error {
+		garbage[hash] = locations
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.update(func(s *Status) { s.Pending = len(garbage) })
+
+	for hash := range garbage {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Чанк мог снова понадобиться новому файлу или загрузке после постановки
+		// в очередь. Пока чанк помечен удаляемым, загрузки его не используют.
+		locations, revived, err := c.Store.ClaimGarbage(hash)
+		if err != nil {
+			return err
+		}
+		if revived {
+			c.update(func(s *Status) { s.Revived++ })
+			continue
+		}
+		if locations == nil {
+			continue
+		}
+
+		remaining := c.deleteLocations(locations)
+		if err := c.Store.FinishGarbage(hash, remaining); err != nil {
+			return err
+		}
+
+		c.update(func(s *Status) {
+			if len(remaining) == 0 {
+				s.Deleted++
+			} else {
+				s.Failed++
+			}
+		})
+	}
+
+	return nil
+}
+
+// deleteLocations удаляет чанк (или его шарды) со всех серверов и
+// возвращает размещения, которые удалить не удалось
+func (c *Collector) deleteLocations(locations []metastore.ChunkInfo) []metastore.ChunkInfo {
+	var remaining []metastore.ChunkInfo
+	for _, ci := range locations {
+		if len(ci.Shards) == 0 {
+			if err := c.Storage.DeleteChunk(ci.ChunkID, ci.NodeURL); err != nil {
+				log.Printf("Failed to delete chunk %s from %s: %v", ci.ChunkID, ci.NodeURL, err)
+				remaining = append(remaining, ci)
+			}
+			continue
+		}
+
+		left := ci
+		left.Shards = nil
+		for _, si := range ci.Shards {
+			if err := c.Storage.DeleteChunk(si.ShardID, si.NodeURL); err != nil {
+				log.Printf("Failed to delete shard %s from %s: %v", si.ShardID, si.NodeURL, err)
+				left.Shards = append(left.Shards, si)
+			}
+		}
+		if len(left.Shards) > 0 {
+			remaining = append(remaining, left)
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,120 @@
+package janitor
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+)
+
+// PendingUpload описывает незавершенную загрузку
+type PendingUpload struct {
+	FileID    string    `json:"fileID"`
+	Filename  string    `json:"filename"`
+	Chunks    int       `json:"chunks"`
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Janitor удаляет загрузки, которые не завершились и не проявляли
+// активности дольше TTL, освобождая ссылки на их чанки
+type Janitor struct {
+	Store    metastore.MetaStore
+	TTL      time.Duration // Время неактивности, после которого загрузка считается брошенной
+	Interval time.Duration // Период между проверками
+	OnExpire func()        // Вызывается после удаления хотя бы одной загрузки
+}
+
+// New создает новый Janitor
+func New(store metastore.MetaStore) *Janitor {
+	return &Janitor{
+		Store:    store,
+		TTL:      24 * time.Hour,
+		Interval: 10 * time.Minute,
+	}
+}
+
+// Run запускает периодические проверки до отмены контекста
+func (j *Janitor) Run(ctx context.Context) {
+	if j.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := j.RunOnce(time.Now()); err != nil {
+			log.Printf("Janitor pass failed: %v", err)
+		}
+	}
+}
+
+// RunOnce удаляет загрузки, просроченные на момент now, и возвращает их количество
+func (j *Janitor) RunOnce(now time.Time) (int, error) {
+	pending, err := j.Pending()
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, u := range pending {
+		if u.ExpiresAt.After(now) {
+			continue
+		}
+
+		if err := j.Store.DeleteFile(u.FileID); err != nil {
+			log.Printf("Failed to expire upload %s: %v", u.FileID, err)
+			continue
+		}
+		log.Printf("Expired abandoned upload %s (%s), last activity at %s", u.FileID, u.Filename, u.UpdatedAt.Format(time.RFC3339))
+		expired++
+	}
+
+	if expired > 0 && j.OnExpire != nil {
+		j.OnExpire()
+	}
+
+	return expired, nil
+}
+
+// Pending возвращает список незавершенных загрузок, начиная с самых старых
+func (j *Janitor) Pending() ([]PendingUpload, error) {
+	pending := []PendingUpload{}
+	err := j.Store.ForEachFile(func(meta *metastore.FileMeta) error {
+		if meta.Complete {
+			return nil
+		}
+
+		// Для записей, созданных до учета активности, отсчитываем от нуля
+		updated := meta.UpdatedAt
+		if updated.Before(meta.StartedAt) {
+			updated = meta.StartedAt
+		}
+
+		pending = append(pending, PendingUpload{
+			FileID:    meta.FileID,
+			Filename:  meta.Filename,
+			Chunks:    len(meta.Chunks),
+			StartedAt: meta.StartedAt,
+			UpdatedAt: updated,
+			ExpiresAt: updated.Add(j.TTL),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(pending, func(a, b int) bool { return pending[a].UpdatedAt.Before(pending[b].UpdatedAt) })
+	return pending, nil
+}
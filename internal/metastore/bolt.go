@@ -2,21 +2,35 @@
 package metastore
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
 
 var (
-	filesBucket  = []byte("files")
-	chunksBucket = []byte("chunks")
+	filesBucket       = []byte("files")
+	chunksBucket      = []byte("chunks")
+	refsBucket        = []byte("refs")
+	locationsBucket   = []byte("locations")
+	garbageBucket     = []byte("garbage")
+	nodesBucket       = []byte("nodes")
+	checkpointsBucket = []byte("checkpoints")
 )
 
 // BoltStore реализация MetaStore на основе BoltDB
 type BoltStore struct {
 	db *bolt.DB
+
+	// Отметки загрузок и удаляемые чанки живут только в памяти: после
+	// перезапуска незавершенных загрузок и удалений не остается
+	mu       sync.Mutex
+	cond     *sync.Cond
+	reserved map[string]int  // Хеш -> число загрузок, собирающихся сослаться на чанк
+	deleting map[string]bool // Чанки, которые сейчас удаляет сборщик мусора
 }
 
 // NewBoltStore создает новое хранилище метаданных на основе BoltDB
@@ -38,25 +52,60 @@ func NewBoltStore(path string) (*BoltStore, error) {
 		if err != nil {
 			return err
 		}
+		_, err = tx.CreateBucketIfNotExists(garbageBucket)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(nodesBucket)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(checkpointsBucket)
+		if err != nil {
+			return err
+		}
+
+		// Счетчики ссылок и размещения появились позже остальных бакетов:
+		// для существующей базы пересчитываем их по файлам
+		if tx.Bucket(refsBucket) == nil {
+			if _, err := tx.CreateBucket(refsBucket); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucketIfNotExists(locationsBucket); err != nil {
+				return err
+			}
+			return rebuildRefs(tx)
+		}
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &BoltStore{db: db}, nil
+	bs := &BoltStore{
+		db:       db,
+		reserved: make(map[string]int),
+		deleting: make(map[string]bool),
+	}
+	bs.cond = sync.NewCond(&bs.mu)
+	return bs, nil
 }
 
 // InitFile инициализирует новую запись о файле
-func (bs *BoltStore) InitFile(fileID, filename string, size int64) error {
+func (bs *BoltStore) InitFile(fileID, filename string, size int64, chunking string, durability DurabilityPolicy) error {
+	now := time.Now()
 	return bs.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(filesBucket)
 		meta := FileMeta{
 			FileID:      fileID,
 			Filename:    filename,
+			Chunking:    chunking,
+			Durability:  durability,
 			TotalChunks: 0,
 			Chunks:      make(map[int][]ChunkInfo),
 			Complete:    false,
+			StartedAt:   now,
+			UpdatedAt:   now,
 		}
 
 		encoded, err := json.Marshal(meta)
@@ -75,7 +124,7 @@ func (bs *BoltStore) SaveChunk(fileID string, index int, info ChunkInfo) error {
 
 		data := b.Get([]byte(fileID))
 		if data == nil {
-			return fmt.Errorf("file not found: %s", fileID)
+			return fmt.Errorf("%w: %s", ErrFileNotFound, fileID)
 		}
 
 		var meta FileMeta
@@ -86,6 +135,14 @@ func (bs *BoltStore) SaveChunk(fileID string, index int, info ChunkInfo) error {
 		// Добавляем или обновляем информацию о чанке
 		if _, exists := meta.Chunks[index]; !exists {
 			meta.Chunks[index] = []ChunkInfo{info}
+
+			// Новый чанк файла - еще одна ссылка на содержимое
+			if err := addRef(tx, info.ChunkID, 1); err != nil {
+				return err
+			}
+		} else if existing := meta.Chunks[index]; len(existing) > 0 && existing[0].ChunkID != info.ChunkID {
+			// Часть уже записана параллельным запросом с другим содержимым
+			return fmt.Errorf("%w: chunk %d of file %s", ErrChunkConflict, index, fileID)
 		} else {
 			// Проверяем, нет ли уже такого узла
 			exists := false
@@ -100,10 +157,16 @@ func (bs *BoltStore) SaveChunk(fileID string, index int, info ChunkInfo) error {
 			}
 		}
 
+		// Запоминаем размещение, чтобы сборщик мусора нашел все копии
+		if err := addLocations(tx, info.ChunkID, info); err != nil {
+			return err
+		}
+
 		// Обновляем общее количество чанков, если нужно
 		if index+1 > meta.TotalChunks {
 			meta.TotalChunks = index + 1
 		}
+		meta.UpdatedAt = time.Now()
 
 		encoded, err := json.Marshal(meta)
 		if err != nil {
@@ -151,6 +214,17 @@ func (bs *BoltStore) HasChunkByHash(hash string) (bool, ChunkInfo, error) {
 	return info.ChunkID != "", info, nil
 }
 
+// ChunkLocations возвращает все известные размещения чанка
+func (bs *BoltStore) ChunkLocations(hash string) ([]ChunkInfo, error) {
+	var locations []ChunkInfo
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		var err error
+		locations, err = getLocations(tx, hash)
+		return err
+	})
+	return locations, err
+}
+
 // GetFileMeta возвращает метаданные о файле
 func (bs *BoltStore) GetFileMeta(fileID string) (*FileMeta, error) {
 	var meta FileMeta
@@ -160,7 +234,7 @@ func (bs *BoltStore) GetFileMeta(fileID string) (*FileMeta, error) {
 		data := b.Get([]byte(fileID))
 
 		if data == nil {
-			return fmt.Errorf("file not found: %s", fileID)
+			return fmt.Errorf("%w: %s", ErrFileNotFound, fileID)
 		}
 
 		return json.Unmarshal(data, &meta)
@@ -173,14 +247,15 @@ func (bs *BoltStore) GetFileMeta(fileID string) (*FileMeta, error) {
 	return &meta, nil
 }
 
-// MarkComplete помечает файл как полностью загруженный
+// MarkComplete помечает файл как полностью загруженный и вычисляет
+// размер файла и смещения его частей
 func (bs *BoltStore) MarkComplete(fileID string) error {
 	return bs.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(filesBucket)
 
 		data := b.Get([]byte(fileID))
 		if data == nil {
-			return fmt.Errorf("file not found: %s", fileID)
+			return fmt.Errorf("%w: %s", ErrFileNotFound, fileID)
 		}
 
 		var meta FileMeta
@@ -189,6 +264,16 @@ func (bs *BoltStore) MarkComplete(fileID string) error {
 		}
 
 		meta.Complete = true
+		meta.UpdatedAt = time.Now()
+
+		meta.Size = 0
+		meta.ChunkOffsets = make([]int64, meta.TotalChunks)
+		for i := 0; i < meta.TotalChunks; i++ {
+			meta.ChunkOffsets[i] = meta.Size
+			if replicas := meta.Chunks[i]; len(replicas) > 0 {
+				meta.Size += replicas[0].Size
+			}
+		}
 
 		encoded, err := json.Marshal(meta)
 		if err != nil {
@@ -199,7 +284,514 @@ func (bs *BoltStore) MarkComplete(fileID string) error {
 	})
 }
 
+// UpdateChunk заменяет размещение чанка во всех ссылающихся на него файлах
+// и в записи для дедупликации
+func (bs *BoltStore) UpdateChunk(hash string, hint []ChunkRef, update func(replicas []ChunkInfo) []ChunkInfo) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(filesBucket)
+
+		seen := make(map[ChunkRef]bool, len(hint))
+
+		// updateFile обновляет еще не обновленные ссылки файла на чанк и
+		// возвращает их число
+		updateFile := func(fileID string, data []byte) (int, error) {
+			var meta FileMeta
+			if err := json.Unmarshal(data, &meta); err != nil {
+				return 0, err
+			}
+
+			found := 0
+			for index, infos := range meta.Chunks {
+				ref := ChunkRef{FileID: fileID, Index: index}
+				if len(infos) == 0 || infos[0].ChunkID != hash || seen[ref] {
+					continue
+				}
+				seen[ref] = true
+				found++
+				meta.Chunks[index] = update(infos)
+				if err := addLocations(tx, hash, meta.Chunks[index]...); err != nil {
+					return 0, err
+				}
+			}
+			if found == 0 {
+				return 0, nil
+			}
+
+			encoded, err := json.Marshal(meta)
+			if err != nil {
+				return 0, err
+			}
+			return found, b.Put([]byte(fileID), encoded)
+		}
+
+		refs, err := refCount(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		// Сначала обновляем файлы из известных ссылок
+		var found int64
+		for _, ref := range hint {
+			data := b.Get([]byte(ref.FileID))
+			if data == nil {
+				continue
+			}
+			n, err := updateFile(ref.FileID, data)
+			if err != nil {
+				return err
+			}
+			found += int64(n)
+		}
+
+		// Ссылки появились после сбора hint: ищем их по всем файлам.
+		// Изменять бакет во время обхода нельзя, поэтому сначала собираем файлы.
+		if found < refs {
+			var ids []string
+			err := b.ForEach(func(k, data []byte) error {
+				var meta FileMeta
+				if err := json.Unmarshal(data, &meta); err != nil {
+					return err
+				}
+				for index, infos := range meta.Chunks {
+					if len(infos) > 0 && infos[0].ChunkID == hash && !seen[ChunkRef{FileID: string(k), Index: index}] {
+						ids = append(ids, string(k))
+						break
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, id := range ids {
+				if _, err := updateFile(id, b.Get([]byte(id))); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Запись для дедупликации обновляется, только если чанк еще используется
+		data := tx.Bucket(chunksBucket).Get([]byte(hash))
+		if data == nil {
+			return nil
+		}
+		var info ChunkInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			return err
+		}
+		updated := update([]ChunkInfo{info})
+		if len(updated) == 0 {
+			return nil
+		}
+		encoded, err := json.Marshal(updated[0])
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(chunksBucket).Put([]byte(hash), encoded)
+	})
+}
+
+// ForEachFile вызывает fn для метаданных каждого файла.
+// fn вызывается внутри транзакции чтения и не должна обращаться к хранилищу.
+func (bs *BoltStore) ForEachFile(fn func(meta *FileMeta) error) error {
+	return bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(filesBucket).ForEach(func(_, data []byte) error {
+			var meta FileMeta
+			if err := json.Unmarshal(data, &meta); err != nil {
+				return err
+			}
+			return fn(&meta)
+		})
+	})
+}
+
+// ForEachChunkHash вызывает fn для каждой записи о чанке по хешу.
+// fn вызывается внутри транзакции чтения и не должна обращаться к хранилищу.
+func (bs *BoltStore) ForEachChunkHash(fn func(hash string, info ChunkInfo) error) error {
+	return bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(chunksBucket).ForEach(func(k, data []byte) error {
+			var info ChunkInfo
+			if err := json.Unmarshal(data, &info); err != nil {
+				return err
+			}
+			return fn(string(k), info)
+		})
+	})
+}
+
+// DeleteFile удаляет метаданные файла и уменьшает счетчики ссылок его чанков.
+// Чанки, на которые больше никто не ссылается, переносятся в очередь на удаление
+// вместе со всеми известными размещениями.
+func (bs *BoltStore) DeleteFile(fileID string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		files := tx.Bucket(filesBucket)
+		chunks := tx.Bucket(chunksBucket)
+
+		data := files.Get([]byte(fileID))
+		if data == nil {
+			return fmt.Errorf("%w: %s", ErrFileNotFound, fileID)
+		}
+
+		var meta FileMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return err
+		}
+
+		// Один и тот же чанк может встречаться в файле несколько раз
+		counts := make(map[string]int64)
+		locations := make(map[string][]ChunkInfo)
+		for _, infos := range meta.Chunks {
+			if len(infos) == 0 {
+				continue
+			}
+			hash := infos[0].ChunkID
+			counts[hash]++
+			locations[hash] = mergeLocations(locations[hash], infos...)
+		}
+
+		for hash, n := range counts {
+			left, err := refCount(tx, hash)
+			if err != nil {
+				return err
+			}
+			if left > n {
+				if err := addRef(tx, hash, -n); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// Ссылок не осталось: собираем все размещения и ставим чанк в очередь GC
+			if err := tx.Bucket(refsBucket).Delete([]byte(hash)); err != nil {
+				return err
+			}
+			known, err := getLocations(tx, hash)
+			if err != nil {
+				return err
+			}
+			locations[hash] = mergeLocations(locations[hash], known...)
+			if err := tx.Bucket(locationsBucket).Delete([]byte(hash)); err != nil {
+				return err
+			}
+			if data := chunks.Get([]byte(hash)); data != nil {
+				var ci ChunkInfo
+				if err := json.Unmarshal(data, &ci); err != nil {
+					return err
+				}
+				locations[hash] = mergeLocations(locations[hash], ci)
+				if err := chunks.Delete([]byte(hash)); err != nil {
+					return err
+				}
+			}
+
+			garbage, err := getGarbage(tx, hash)
+			if err != nil {
+				return err
+			}
+			if err := putGarbage(tx, hash, mergeLocations(garbage, locations[hash]...)); err != nil {
+				return err
+			}
+		}
+
+		return files.Delete([]byte(fileID))
+	})
+}
+
+// ChunkRefCount возвращает количество ссылок файлов на чанк
+func (bs *BoltStore) ChunkRefCount(hash string) (int64, error) {
+	var n int64
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = refCount(tx, hash)
+		return err
+	})
+	return n, err
+}
+
+// ForEachGarbage вызывает fn для каждого чанка в очереди на удаление.
+// fn вызывается внутри транзакции чтения и не должна обращаться к хранилищу.
+func (bs *BoltStore) ForEachGarbage(fn func(hash string, locations []ChunkInfo) error) error {
+	return bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(garbageBucket).ForEach(func(k, data []byte) error {
+			var locations []ChunkInfo
+			if err := json.Unmarshal(data, &locations); err != nil {
+				return err
+			}
+			return fn(string(k), locations)
+		})
+	})
+}
+
+// SetGarbage заменяет список неудаленных размещений чанка в очереди на удаление.
+// Пустой список убирает чанк из очереди.
+func (bs *BoltStore) SetGarbage(hash string, locations []ChunkInfo) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		if len(locations) == 0 {
+			return tx.Bucket(garbageBucket).Delete([]byte(hash))
+		}
+		return putGarbage(tx, hash, locations)
+	})
+}
+
+// ReserveChunk отмечает, что загрузка собирается сослаться на чанк
+func (bs *BoltStore) ReserveChunk(hash string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	for bs.deleting[hash] {
+		bs.cond.Wait()
+	}
+	bs.reserved[hash]++
+}
+
+// ReleaseChunk снимает отметку ReserveChunk
+func (bs *BoltStore) ReleaseChunk(hash string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.reserved[hash]--; bs.reserved[hash] <= 0 {
+		delete(bs.reserved, hash)
+	}
+}
+
+// ClaimGarbage проверяет ссылки на чанк из очереди на удаление и помечает
+// его удаляемым. Проверка и пометка выполняются под одной блокировкой
+// с ReserveChunk, поэтому загрузка либо успевает отметить чанк и сохраняет
+// его, либо дожидается удаления и записывает копии заново.
+func (bs *BoltStore) ClaimGarbage(hash string) ([]ChunkInfo, bool, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	var (
+		locations []ChunkInfo
+		revived   bool
+	)
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if locations, err = getGarbage(tx, hash); err != nil || locations == nil {
+			return err
+		}
+
+		refs, err := refCount(tx, hash)
+		if err != nil {
+			return err
+		}
+		if refs > 0 || bs.reserved[hash] > 0 {
+			revived = true
+			return tx.Bucket(garbageBucket).Delete([]byte(hash))
+		}
+		return nil
+	})
+	if err != nil || revived {
+		return nil, revived, err
+	}
+	if locations != nil {
+		bs.deleting[hash] = true
+	}
+	return locations, false, nil
+}
+
+// FinishGarbage записывает неудаленные размещения чанка и снимает пометку
+// удаления, даже если запись не удалась
+func (bs *BoltStore) FinishGarbage(hash string, remaining []ChunkInfo) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	defer bs.cond.Broadcast()
+	delete(bs.deleting, hash)
+
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		if len(remaining) == 0 {
+			return tx.Bucket(garbageBucket).Delete([]byte(hash))
+		}
+		return putGarbage(tx, hash, remaining)
+	})
+}
+
+// SaveNode сохраняет запись о сервере хранения
+func (bs *BoltStore) SaveNode(node NodeRecord) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		encoded, err := json.Marshal(node)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(nodesBucket).Put([]byte(node.URL), encoded)
+	})
+}
+
+// DeleteNode удаляет запись о сервере хранения
+func (bs *BoltStore) DeleteNode(url string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(nodesBucket).Delete([]byte(url))
+	})
+}
+
+// ForEachNode вызывает fn для каждого сохраненного сервера хранения.
+// fn вызывается внутри транзакции чтения и не должна обращаться к хранилищу.
+func (bs *BoltStore) ForEachNode(fn func(node NodeRecord) error) error {
+	return bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(nodesBucket).ForEach(func(k, data []byte) error {
+			var node NodeRecord
+			if err := json.Unmarshal(data, &node); err != nil {
+				return err
+			}
+			return fn(node)
+		})
+	})
+}
+
+// SaveCheckpoint сохраняет состояние фонового процесса под именем name.
+// Пустое значение удаляет состояние.
+func (bs *BoltStore) SaveCheckpoint(name string, value []byte) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(checkpointsBucket)
+		if len(value) == 0 {
+			return b.Delete([]byte(name))
+		}
+		return b.Put([]byte(name), value)
+	})
+}
+
+// LoadCheckpoint возвращает сохраненное состояние или nil, если его нет
+func (bs *BoltStore) LoadCheckpoint(name string) ([]byte, error) {
+	var value []byte
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(checkpointsBucket).Get([]byte(name)); data != nil {
+			value = append([]byte(nil), data...)
+		}
+		return nil
+	})
+	return value, err
+}
+
 // Close закрывает хранилище
 func (bs *BoltStore) Close() error {
 	return bs.db.Close()
 }
+
+// refCount возвращает счетчик ссылок на чанк
+func refCount(tx *bolt.Tx, hash string) (int64, error) {
+	data := tx.Bucket(refsBucket).Get([]byte(hash))
+	if data == nil {
+		return 0, nil
+	}
+	if len(data) != 8 {
+		return 0, fmt.Errorf("invalid ref count for chunk %s", hash)
+	}
+	return int64(binary.BigEndian.Uint64(data)), nil
+}
+
+// addRef изменяет счетчик ссылок на чанк на delta
+func addRef(tx *bolt.Tx, hash string, delta int64) error {
+	n, err := refCount(tx, hash)
+	if err != nil {
+		return err
+	}
+	n += delta
+	if n <= 0 {
+		return tx.Bucket(refsBucket).Delete([]byte(hash))
+	}
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(n))
+	return tx.Bucket(refsBucket).Put([]byte(hash), buf)
+}
+
+// getLocations возвращает все известные размещения чанка
+func getLocations(tx *bolt.Tx, hash string) ([]ChunkInfo, error) {
+	data := tx.Bucket(locationsBucket).Get([]byte(hash))
+	if data == nil {
+		return nil, nil
+	}
+	var locations []ChunkInfo
+	err := json.Unmarshal(data, &locations)
+	return locations, err
+}
+
+// addLocations добавляет размещения чанка к уже известным
+func addLocations(tx *bolt.Tx, hash string, infos ...ChunkInfo) error {
+	locations, err := getLocations(tx, hash)
+	if err != nil {
+		return err
+	}
+	merged := mergeLocations(locations, infos...)
+	if len(merged) == len(locations) {
+		return nil
+	}
+
+	encoded, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(locationsBucket).Put([]byte(hash), encoded)
+}
+
+// rebuildRefs пересчитывает счетчики ссылок и размещения чанков по всем файлам
+func rebuildRefs(tx *bolt.Tx) error {
+	return tx.Bucket(filesBucket).ForEach(func(_, data []byte) error {
+		var meta FileMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return err
+		}
+		for _, infos := range meta.Chunks {
+			if len(infos) == 0 {
+				continue
+			}
+			if err := addRef(tx, infos[0].ChunkID, 1); err != nil {
+				return err
+			}
+			if err := addLocations(tx, infos[0].ChunkID, infos...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// getGarbage возвращает размещения чанка из очереди на удаление
+func getGarbage(tx *bolt.Tx, hash string) ([]ChunkInfo, error) {
+	data := tx.Bucket(garbageBucket).Get([]byte(hash))
+	if data == nil {
+		return nil, nil
+	}
+	var locations []ChunkInfo
+	err := json.Unmarshal(data, &locations)
+	return locations, err
+}
+
+// putGarbage записывает размещения чанка в очередь на удаление
+func putGarbage(tx *bolt.Tx, hash string, locations []ChunkInfo) error {
+	encoded, err := json.Marshal(locations)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(garbageBucket).Put([]byte(hash), encoded)
+}
+
+// mergeLocations добавляет к списку размещения, которых в нем еще нет
+func mergeLocations(list []ChunkInfo, infos ...ChunkInfo) []ChunkInfo {
+	for _, ci := range infos {
+		exists := false
+		for _, v := range list {
+			if sameLocation(v, ci) {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			list = append(list, ci)
+		}
+	}
+	return list
+}
+
+// sameLocation проверяет, описывают ли две записи одно и то же размещение
+func sameLocation(a, b ChunkInfo) bool {
+	if a.ChunkID != b.ChunkID || a.NodeURL != b.NodeURL || len(a.Shards) != len(b.Shards) {
+		return false
+	}
+	for i := range a.Shards {
+		if a.Shards[i] != b.Shards[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,262 @@
+package metastore
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// openTestStore открывает BoltStore во временной директории теста
+func openTestStore(t *testing.T) *BoltStore {
+	t.Helper()
+
+	bs, err := NewBoltStore(filepath.Join(t.TempDir(), "meta.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bs.Close() })
+	return bs
+}
+
+// initFile создает файл или завершает тест с ошибкой
+func initFile(t *testing.T, bs *BoltStore, fileID string) {
+	t.Helper()
+
+	if err := bs.InitFile(fileID, fileID+".bin", 0, "fixed", DurabilityPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// saveChunk записывает копию чанка файла или завершает тест с ошибкой
+func saveChunk(t *testing.T, bs *BoltStore, fileID string, index int, hash, node string) {
+	t.Helper()
+
+	if err := bs.SaveChunk(fileID, index, ChunkInfo{ChunkID: hash, NodeURL: node, Size: 10}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveChunkConflict(t *testing.T) {
+	bs := openTestStore(t)
+	initFile(t, bs, "upload")
+
+	saveChunk(t, bs, "upload", 0, "aaaa", "http://node-1")
+	saveChunk(t, bs, "upload", 0, "aaaa", "http://node-2")
+
+	err := bs.SaveChunk("upload", 0, ChunkInfo{ChunkID: "bbbb", NodeURL: "http://node-3"})
+	if !errors.Is(err, ErrChunkConflict) {
+		t.Fatalf("SaveChunk with other content = %v, want %v", err, ErrChunkConflict)
+	}
+
+	meta, err := bs.GetFileMeta("upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := meta.Chunks[0]; len(got) != 2 || got[0].ChunkID != "aaaa" || got[1].ChunkID != "aaaa" {
+		t.Errorf("chunk 0 = %v, want two copies of aaaa", got)
+	}
+	for hash, want := range map[string]int64{"aaaa": 1, "bbbb": 0} {
+		if n, err := bs.ChunkRefCount(hash); err != nil || n != want {
+			t.Errorf("ChunkRefCount(%s) = %d, %v, want %d", hash, n, err, want)
+		}
+	}
+}
+
+// garbageOf возвращает размещения чанка из очереди на удаление
+func garbageOf(t *testing.T, bs *BoltStore, hash string) []ChunkInfo {
+	t.Helper()
+
+	var result []ChunkInfo
+	err := bs.ForEachGarbage(func(h string, locations []ChunkInfo) error {
+		if h == hash {
+			result = locations
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// checkRefs сравнивает счетчики ссылок на чанки с ожидаемыми
+func checkRefs(t *testing.T, bs *BoltStore, want map[string]int64) {
+	t.Helper()
+
+	for hash, n := range want {
+		if got, err := bs.ChunkRefCount(hash); err != nil || got != n {
+			t.Errorf("ChunkRefCount(%s) = %d, %v, want %d", hash, got, err, n)
+		}
+	}
+}
+
+func TestDeleteFileSharedChunk(t *testing.T) {
+	bs := openTestStore(t)
+	initFile(t, bs, "a")
+	initFile(t, bs, "b")
+	saveChunk(t, bs, "a", 0, "shared", "http://node-1")
+	saveChunk(t, bs, "a", 1, "only-a", "http://node-1")
+	saveChunk(t, bs, "b", 0, "shared", "http://node-2")
+	checkRefs(t, bs, map[string]int64{"shared": 2, "only-a": 1})
+
+	if err := bs.DeleteFile("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Общий чанк остается, чанк только удаленного файла уходит в очередь GC
+	checkRefs(t, bs, map[string]int64{"shared": 1, "only-a": 0})
+	if got := garbageOf(t, bs, "shared"); got != nil {
+		t.Errorf("shared chunk queued for deletion: %v", got)
+	}
+	if got := garbageOf(t, bs, "only-a"); len(got) != 1 || got[0].NodeURL != "http://node-1" {
+		t.Errorf("garbage of only-a = %v, want copy on node-1", got)
+	}
+
+	// После удаления последнего файла удаляются копии, записанные обоими файлами
+	if err := bs.DeleteFile("b"); err != nil {
+		t.Fatal(err)
+	}
+	checkRefs(t, bs, map[string]int64{"shared": 0})
+	got := garbageOf(t, bs, "shared")
+	if len(got) != 2 {
+		t.Fatalf("garbage of shared = %v, want copies on node-1 and node-2", got)
+	}
+	if found, _, _ := bs.HasChunkByHash("shared"); found {
+		t.Error("deleted chunk is still found by hash")
+	}
+}
+
+func TestReserveChunkRevivesGarbage(t *testing.T) {
+	bs := openTestStore(t)
+	initFile(t, bs, "a")
+	saveChunk(t, bs, "a", 0, "aaaa", "http://node-1")
+	if err := bs.DeleteFile("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Загрузка отметила чанк раньше сборщика: чанк не удаляется
+	bs.ReserveChunk("aaaa")
+	locations, revived, err := bs.ClaimGarbage("aaaa")
+	bs.ReleaseChunk("aaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revived || locations != nil {
+		t.Fatalf("ClaimGarbage = %v, %v, want revived", locations, revived)
+	}
+	if got := garbageOf(t, bs, "aaaa"); got != nil {
+		t.Errorf("revived chunk still queued: %v", got)
+	}
+}
+
+func TestClaimGarbageBlocksReserve(t *testing.T) {
+	bs := openTestStore(t)
+	initFile(t, bs, "a")
+	saveChunk(t, bs, "a", 0, "aaaa", "http://node-1")
+	if err := bs.DeleteFile("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	locations, revived, err := bs.ClaimGarbage("aaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revived || len(locations) != 1 {
+		t.Fatalf("ClaimGarbage = %v, %v, want one location to delete", locations, revived)
+	}
+
+	// Загрузка ждет окончания удаления, чтобы записать копии заново
+	reserved := make(chan struct{})
+	go func() {
+		bs.ReserveChunk("aaaa")
+		close(reserved)
+	}()
+	select {
+	case <-reserved:
+		t.Fatal("ReserveChunk returned while the chunk is being deleted")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := bs.FinishGarbage("aaaa", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reserved:
+	case <-time.After(time.Second):
+		t.Fatal("ReserveChunk still blocked after FinishGarbage")
+	}
+	bs.ReleaseChunk("aaaa")
+
+	if got := garbageOf(t, bs, "aaaa"); got != nil {
+		t.Errorf("deleted chunk still queued: %v", got)
+	}
+}
+
+func TestClaimGarbageRevivedByNewFile(t *testing.T) {
+	bs := openTestStore(t)
+	initFile(t, bs, "a")
+	saveChunk(t, bs, "a", 0, "aaaa", "http://node-1")
+	if err := bs.DeleteFile("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Новый файл сослался на чанк до прохода сборщика
+	initFile(t, bs, "b")
+	saveChunk(t, bs, "b", 0, "aaaa", "http://node-1")
+
+	locations, revived, err := bs.ClaimGarbage("aaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revived || locations != nil {
+		t.Fatalf("ClaimGarbage = %v, %v, want revived", locations, revived)
+	}
+	checkRefs(t, bs, map[string]int64{"aaaa": 1})
+	if got := garbageOf(t, bs, "aaaa"); got != nil {
+		t.Errorf("revived chunk still queued: %v", got)
+	}
+}
+
+func TestRebuildRefs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meta.db")
+	bs, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initFile(t, bs, "a")
+	initFile(t, bs, "b")
+	saveChunk(t, bs, "a", 0, "shared", "http://node-1")
+	saveChunk(t, bs, "a", 1, "shared", "http://node-1")
+	saveChunk(t, bs, "a", 2, "only-a", "http://node-2")
+	saveChunk(t, bs, "b", 0, "shared", "http://node-3")
+
+	// База до появления счетчиков ссылок и размещений
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(refsBucket); err != nil {
+			return err
+		}
+		return tx.DeleteBucket(locationsBucket)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs.Close()
+
+	bs, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+
+	checkRefs(t, bs, map[string]int64{"shared": 3, "only-a": 1})
+	locations, err := bs.ChunkLocations("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locations) != 2 {
+		t.Errorf("locations of shared = %v, want node-1 and node-3", locations)
+	}
+}
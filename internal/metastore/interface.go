@@ -1,26 +1,85 @@
 package metastore
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrFileNotFound возвращается, если файла нет в хранилище
+var ErrFileNotFound = errors.New("file not found")
+
+// ErrChunkConflict возвращается, если часть файла уже записана с другим содержимым
+var ErrChunkConflict = errors.New("chunk already saved with different content")
+
+// Режимы обеспечения надежности хранения
+const (
+	DurabilityReplication = "replication" // полные копии чанков на нескольких серверах
+	DurabilityErasure     = "erasure"     // шарды данных и четности (Reed-Solomon)
+)
+
 // ChunkInfo содержит информацию о чанке
 type ChunkInfo struct {
-	ChunkID string // SHA-256 хеш содержимого чанка
-	NodeURL string // URL сервера хранения, где находится чанк
+	ChunkID    string      // SHA-256 хеш содержимого чанка
+	NodeURL    string      // URL сервера хранения, где находится чанк (пусто для erasure)
+	Size       int64       // Размер чанка в байтах
+	DataShards int         // Количество шардов данных среди Shards
+	Shards     []ShardInfo // Шарды чанка, если он хранится с erasure coding
+}
+
+// ShardInfo содержит информацию о шарде чанка
+type ShardInfo struct {
+	Index   int    // Номер шарда: [0, DataShards) - данные, дальше - четность
+	ShardID string // SHA-256 хеш содержимого шарда
+	NodeURL string // URL сервера хранения, где находится шард
+}
+
+// DurabilityPolicy описывает способ обеспечения надежности хранения файла
+type DurabilityPolicy struct {
+	Mode         string // DurabilityReplication или DurabilityErasure
+	Replicas     int    // Количество копий для DurabilityReplication
+	MinReplicas  int    // Минимальное количество подтвержденных копий (write concern)
+	DataShards   int    // Количество шардов данных (k) для DurabilityErasure
+	ParityShards int    // Количество шардов четности (m) для DurabilityErasure
 }
 
 // FileMeta содержит метаданные о файле
 type FileMeta struct {
-	FileID      string              // Уникальный идентификатор файла
-	Filename    string              // Имя файла
-	TotalChunks int                 // Общее количество частей
-	Chunks      map[int][]ChunkInfo // Карта индексов частей к информации о частях (с репликами)
-	Complete    bool                // Флаг завершенности загрузки
+	FileID       string              // Уникальный идентификатор файла
+	Filename     string              // Имя файла
+	Chunking     string              // Алгоритм разбиения на чанки ("fixed" или "cdc")
+	Durability   DurabilityPolicy    // Политика надежности хранения
+	TotalChunks  int                 // Общее количество частей
+	Size         int64               // Размер файла в байтах (известен после завершения загрузки)
+	ChunkOffsets []int64             // Смещение каждой части в файле (заполняется при завершении загрузки)
+	Chunks       map[int][]ChunkInfo // Карта индексов частей к информации о частях (с репликами)
+	Complete     bool                // Флаг завершенности загрузки
+	StartedAt    time.Time           // Время начала загрузки
+	UpdatedAt    time.Time           // Время последней активности загрузки
+}
+
+// ChunkRef ссылка на чанк внутри файла
+type ChunkRef struct {
+	FileID string // Идентификатор файла
+	Index  int    // Индекс части в файле
+}
+
+// NodeRecord содержит информацию о сервере хранения, добавленном во время работы
+// или выводимом из эксплуатации
+type NodeRecord struct {
+	URL          string    // URL сервера хранения
+	NodeID       string    // Идентификатор сервера
+	Source       string    // Способ добавления: через параметры запуска, админ API или по heartbeat
+	RegisteredAt time.Time // Время добавления
+	Drain        string    // Состояние вывода сервера из эксплуатации
 }
 
 // MetaStore интерфейс для хранения метаданных
 type MetaStore interface {
 	// InitFile инициализирует новую запись о файле
-	InitFile(fileID, filename string, size int64) error
+	InitFile(fileID, filename string, size int64, chunking string, durability DurabilityPolicy) error
 
-	// SaveChunk сохраняет информацию о чанке файла
+	// SaveChunk сохраняет информацию о чанке файла. Если часть index уже
+	// записана с другим хешем, возвращается ErrChunkConflict.
 	SaveChunk(fileID string, index int, info ChunkInfo) error
 
 	// SaveChunkHash сохраняет информацию о чанке по его хешу
@@ -29,12 +88,76 @@ type MetaStore interface {
 	// HasChunkByHash проверяет наличие чанка с указанным хешем
 	HasChunkByHash(hash string) (bool, ChunkInfo, error)
 
+	// ChunkLocations возвращает все известные размещения чанка
+	ChunkLocations(hash string) ([]ChunkInfo, error)
+
 	// GetFileMeta возвращает метаданные о файле
 	GetFileMeta(fileID string) (*FileMeta, error)
 
-	// MarkComplete помечает файл как полностью загруженный
+	// MarkComplete помечает файл как полностью загруженный и вычисляет смещения частей
 	MarkComplete(fileID string) error
 
+	// UpdateChunk в одной транзакции заменяет размещение чанка во всех
+	// ссылающихся на него файлах и в записи для дедупликации: update получает
+	// текущий список размещений и возвращает новый. Ссылки hint проверяются
+	// первыми; остальные файлы просматриваются, только если hint покрывает
+	// не все ссылки на чанк.
+	UpdateChunk(hash string, hint []ChunkRef, update func(replicas []ChunkInfo) []ChunkInfo) error
+
+	// ForEachFile вызывает fn для метаданных каждого файла
+	ForEachFile(fn func(meta *FileMeta) error) error
+
+	// ForEachChunkHash вызывает fn для каждой записи о чанке по хешу
+	ForEachChunkHash(fn func(hash string, info ChunkInfo) error) error
+
+	// DeleteFile удаляет файл и освобождает ссылки на его чанки
+	DeleteFile(fileID string) error
+
+	// ChunkRefCount возвращает количество ссылок файлов на чанк
+	ChunkRefCount(hash string) (int64, error)
+
+	// ForEachGarbage вызывает fn для каждого чанка в очереди на удаление
+	ForEachGarbage(fn func(hash string, locations []ChunkInfo) error) error
+
+	// SetGarbage заменяет список неудаленных размещений чанка в очереди на удаление
+	SetGarbage(hash string, locations []ChunkInfo) error
+
+	// ReserveChunk отмечает, что загрузка собирается сослаться на чанк: найти
+	// его по хешу или записать на серверы. Пока отметка не снята, сборщик мусора
+	// не удаляет чанк. Если чанк удаляется прямо сейчас, ReserveChunk ждет
+	// окончания удаления, чтобы загрузка записала копии заново.
+	ReserveChunk(hash string)
+
+	// ReleaseChunk снимает отметку ReserveChunk после записи ссылки на чанк
+	// (или отказа от нее)
+	ReleaseChunk(hash string)
+
+	// ClaimGarbage атомарно проверяет чанк из очереди на удаление. Если на него
+	// снова ссылаются файлы или загрузки, чанк убирается из очереди и
+	// возвращается revived. Иначе чанк помечается удаляемым до FinishGarbage
+	// и возвращаются его размещения.
+	ClaimGarbage(hash string) (locations []ChunkInfo, revived bool, err error)
+
+	// FinishGarbage записывает неудаленные размещения чанка, помеченного
+	// ClaimGarbage, и снимает пометку
+	FinishGarbage(hash string, remaining []ChunkInfo) error
+
+	// SaveNode сохраняет запись о сервере хранения
+	SaveNode(node NodeRecord) error
+
+	// DeleteNode удаляет запись о сервере хранения
+	DeleteNode(url string) error
+
+	// ForEachNode вызывает fn для каждого сохраненного сервера хранения
+	ForEachNode(fn func(node NodeRecord) error) error
+
+	// SaveCheckpoint сохраняет состояние фонового процесса под именем name.
+	// Пустое значение удаляет состояние.
+	SaveCheckpoint(name string, value []byte) error
+
+	// LoadCheckpoint возвращает сохраненное состояние или nil, если его нет
+	LoadCheckpoint(name string) ([]byte, error)
+
 	// Close закрывает хранилище
 	Close() error
 }
@@ -0,0 +1,215 @@
+package nodestats
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// unitSize размер, к которому нормируется задержка: время скачивания
+// чанка растет с его размером, поэтому сравниваются задержки на МиБ
+const unitSize = 1 << 20
+
+// historySize количество последних замеров для оценки перцентилей
+const historySize = 128
+
+// minSamples минимальное количество замеров для хеджирования
+const minSamples = 16
+
+// NodeStats статистика одного сервера хранения
+type NodeStats struct {
+	Latency   time.Duration `json:"latency"`   // EWMA задержки на МиБ
+	P95       time.Duration `json:"p95"`       // 95-й перцентиль задержки на МиБ
+	ErrorRate float64       `json:"errorRate"` // EWMA доли ошибок
+	InFlight  int           `json:"inFlight"`  // Запросы в процессе выполнения
+	Samples   int           `json:"samples"`   // Общее количество замеров
+}
+
+// node внутреннее состояние статистики сервера
+type node struct {
+	latency  float64 // EWMA, наносекунды на МиБ
+	errRate  float64
+	inFlight int
+	samples  int
+	history  [historySize]time.Duration
+	next     int
+}
+
+// Tracker собирает статистику задержек и ошибок серверов хранения.
+// Методы безопасны для вызова на nil: тогда порядок реплик не меняется
+// и хеджирование отключено.
+type Tracker struct {
+	Alpha float64 // Вес нового замера в EWMA
+
+	mu    sync.Mutex
+	nodes map[string]*node
+}
+
+// NewTracker создает новый Tracker
+func NewTracker() *Tracker {
+	return &Tracker{
+		Alpha: 0.2,
+		nodes: make(map[string]*node),
+	}
+}
+
+// Start отмечает начало запроса к серверу. Возвращаемую функцию нужно
+// вызвать по завершении запроса с количеством байт и ошибкой.
+func (t *Tracker) Start(nodeURL string) func(size int64, err error) {
+	if t == nil {
+		return func(int64, error) {}
+	}
+
+	t.mu.Lock()
+	t.get(nodeURL).inFlight++
+	t.mu.Unlock()
+
+	started := time.Now()
+	return func(size int64, err error) {
+		t.observe(nodeURL, time.Since(started), size, err)
+	}
+}
+
+// observe учитывает завершенный запрос
+func (t *Tracker) observe(nodeURL string, d time.Duration, size int64, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := t.get(nodeURL)
+	n.inFlight--
+
+	// Отмененный запрос ничего не говорит о скорости сервера
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	failed := 0.0
+	if err != nil {
+		failed = 1
+	}
+	n.errRate = t.Alpha*failed + (1-t.Alpha)*n.errRate
+	if err != nil {
+		return
+	}
+
+	perUnit := normalize(d, size)
+	if n.samples == 0 {
+		n.latency = float64(perUnit)
+	} else {
+		n.latency = t.Alpha*float64(perUnit) + (1-t.Alpha)*n.latency
+	}
+	n.history[n.next] = perUnit
+	n.next = (n.next + 1) % historySize
+	n.samples++
+}
+
+// Order возвращает индексы серверов от наиболее к наименее предпочтительному.
+// Учитываются задержка, доля ошибок и текущая нагрузка; при равенстве
+// сохраняется исходный порядок. Серверы без статистики идут первыми,
+// чтобы о них появились данные.
+func (t *Tracker) Order(nodeURLs []string) []int {
+	order := make([]int, len(nodeURLs))
+	for i := range order {
+		order[i] = i
+	}
+	if t == nil {
+		return order
+	}
+
+	t.mu.Lock()
+	scores := make([]float64, len(nodeURLs))
+	for i, url := range nodeURLs {
+		if n, ok := t.nodes[url]; ok && n.samples > 0 {
+			scores[i] = n.latency * (1 + 10*n.errRate) * float64(1+n.inFlight)
+		}
+	}
+	t.mu.Unlock()
+
+	sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] < scores[order[b]] })
+	return order
+}
+
+// HedgeDelay возвращает время ожидания ответа сервера перед отправкой
+// дублирующего запроса к другой реплике: 95-й перцентиль задержки сервера,
+// пересчитанный на размер чанка. Возвращает 0, если данных недостаточно.
+func (t *Tracker) HedgeDelay(nodeURL string, size int64) time.Duration {
+	if t == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, ok := t.nodes[nodeURL]
+	if !ok || n.samples < minSamples {
+		return 0
+	}
+	return scale(n.p95(), size)
+}
+
+// Snapshot возвращает статистику всех известных серверов
+func (t *Tracker) Snapshot() map[string]NodeStats {
+	result := make(map[string]NodeStats)
+	if t == nil {
+		return result
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for url, n := range t.nodes {
+		result[url] = NodeStats{
+			Latency:   time.Duration(n.latency),
+			P95:       n.p95(),
+			ErrorRate: n.errRate,
+			InFlight:  n.inFlight,
+			Samples:   n.samples,
+		}
+	}
+	return result
+}
+
+// get возвращает состояние сервера, создавая его при необходимости
+func (t *Tracker) get(nodeURL string) *node {
+	n, ok := t.nodes[nodeURL]
+	if !ok {
+		n = &node{}
+		t.nodes[nodeURL] = n
+	}
+	return n
+}
+
+// p95 вычисляет 95-й перцентиль по последним замерам
+func (n *node) p95() time.Duration {
+	count := n.samples
+	if count > historySize {
+		count = historySize
+	}
+	if count == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, count)
+	copy(sorted, n.history[:count])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(count*95+99)/100-1]
+}
+
+// normalize пересчитывает задержку на unitSize байт. Чанки меньше
+// unitSize считаются равными ему: для них преобладают накладные расходы.
+func normalize(d time.Duration, size int64) time.Duration {
+	if size <= unitSize {
+		return d
+	}
+	return time.Duration(float64(d) * unitSize / float64(size))
+}
+
+// scale пересчитывает нормированную задержку на размер size
+func scale(perUnit time.Duration, size int64) time.Duration {
+	if size <= unitSize {
+		return perUnit
+	}
+	return time.Duration(float64(perUnit) * float64(size) / unitSize)
+}
@@ -0,0 +1,102 @@
+package placement
+
+// Уровни failure domain
+const (
+	LevelZone = "zone"
+	LevelRack = "rack"
+	LevelHost = "host"
+)
+
+// Domain расположение сервера хранения. Пустая метка означает, что она
+// неизвестна; сервер без метки host считается отдельным хостом.
+type Domain struct {
+	Zone string `json:"zone,omitempty"`
+	Rack string `json:"rack,omitempty"`
+	Host string `json:"host,omitempty"`
+}
+
+// Spread размещение, разносящее копии по разным failure domain. Порядок
+// базового размещения сохраняется, но следующим выбирается первый сервер
+// из еще не занятой зоны, затем стойки, затем хоста.
+type Spread struct {
+	Base    Placement
+	Domains func() map[string]Domain // Расположение серверов
+}
+
+// Rank возвращает серверы так, что любой префикс списка занимает
+// максимально возможное число различных failure domain
+func (p Spread) Rank(key string, nodes []string) []string {
+	ranked := p.Base.Rank(key, nodes)
+	domains := p.Domains()
+
+	zones := make(map[string]bool)
+	racks := make(map[string]bool)
+	hosts := make(map[string]bool)
+
+	result := make([]string, 0, len(ranked))
+	for len(ranked) > 0 {
+		// Ищем первый сервер с наибольшей новизной расположения
+		best, bestLevel := 0, -1
+		for i, node := range ranked {
+			level := 0
+			switch {
+			case !zones[DomainKey(domains, node, LevelZone)]:
+				level = 3
+			case !racks[DomainKey(domains, node, LevelRack)]:
+				level = 2
+			case !hosts[DomainKey(domains, node, LevelHost)]:
+				level = 1
+			}
+			if level > bestLevel {
+				best, bestLevel = i, level
+			}
+			if level == 3 {
+				break
+			}
+		}
+
+		node := ranked[best]
+		ranked = append(ranked[:best:best], ranked[best+1:]...)
+		result = append(result, node)
+		zones[DomainKey(domains, node, LevelZone)] = true
+		racks[DomainKey(domains, node, LevelRack)] = true
+		hosts[DomainKey(domains, node, LevelHost)] = true
+	}
+	return result
+}
+
+// Crowded возвращает failure domain уровня level, в котором находится
+// больше всего серверов из nodes, и количество этих серверов
+func Crowded(domains map[string]Domain, level string, nodes []string) (string, int) {
+	counts := make(map[string]int)
+	var name string
+	max := 0
+	for _, node := range nodes {
+		key := DomainKey(domains, node, level)
+		counts[key]++
+		if counts[key] > max {
+			name, max = key, counts[key]
+		}
+	}
+	return name, max
+}
+
+// DomainKey возвращает идентификатор failure domain сервера на уровне level.
+// Стойка и хост включают вышестоящие уровни, так как их имена уникальны
+// только внутри зоны и стойки.
+func DomainKey(domains map[string]Domain, node, level string) string {
+	d := domains[node]
+	host := d.Host
+	if host == "" {
+		host = node
+	}
+
+	switch level {
+	case LevelZone:
+		return d.Zone
+	case LevelRack:
+		return d.Zone + "/" + d.Rack
+	default:
+		return d.Zone + "/" + d.Rack + "/" + host
+	}
+}
@@ -0,0 +1,106 @@
+package placement
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// testDomains размещает серверы по зонам, по две стойки в зоне
+func testDomains(nodes []string, zones int) map[string]Domain {
+	domains := make(map[string]Domain)
+	for i, node := range nodes {
+		domains[node] = Domain{
+			Zone: fmt.Sprintf("zone-%d", i%zones),
+			Rack: fmt.Sprintf("rack-%d", i/zones%2),
+		}
+	}
+	return domains
+}
+
+// spread возвращает Spread поверх Rendezvous с расположением domains
+func spread(domains map[string]Domain) Spread {
+	return Spread{Base: Rendezvous{}, Domains: func() map[string]Domain { return domains }}
+}
+
+// distinct возвращает число различных failure domain уровня level среди nodes
+func distinct(domains map[string]Domain, level string, nodes []string) int {
+	seen := make(map[string]bool)
+	for _, node := range nodes {
+		seen[DomainKey(domains, node, level)] = true
+	}
+	return len(seen)
+}
+
+func TestSpreadDiversity(t *testing.T) {
+	nodes := testNodes(12)
+
+	tests := []struct {
+		name  string
+		zones int
+		count int
+		want  map[string]int // Ожидаемое число различных доменов по уровням
+	}{
+		{"copies in separate zones", 3, 3, map[string]int{LevelZone: 3}},
+		{"more copies than zones", 2, 3, map[string]int{LevelZone: 2, LevelRack: 3}},
+		{"more copies than racks", 2, 5, map[string]int{LevelZone: 2, LevelRack: 4, LevelHost: 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domains := testDomains(nodes, tt.zones)
+			p := spread(domains)
+
+			for _, key := range testKeys(1000) {
+				chosen := Choose(p, key, nodes, tt.count)
+				for level, want := range tt.want {
+					if got := distinct(domains, level, chosen); got != want {
+						t.Fatalf("key %s: %v spans %d domains at level %s, want %d", key[:8], chosen, got, level, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSpreadKeepsBaseOrder(t *testing.T) {
+	// Без меток все серверы - отдельные хосты, и порядок совпадает с базовым
+	nodes := testNodes(5)
+	p := spread(map[string]Domain{})
+
+	for _, key := range testKeys(100) {
+		if got, want := p.Rank(key, nodes), (Rendezvous{}).Rank(key, nodes); !slices.Equal(got, want) {
+			t.Fatalf("Rank(%s) = %v, want %v", key[:8], got, want)
+		}
+	}
+}
+
+func TestSpreadMovement(t *testing.T) {
+	keys := testKeys(20000)
+
+	// Три зоны по три сервера; новый сервер попадает в первую зону
+	nodes := testNodes(10)
+	domains := testDomains(nodes, 3)
+	p := spread(domains)
+
+	tests := []struct {
+		name          string
+		before, after []string
+		node          string
+		want          float64
+	}{
+		// По одной копии в каждой зоне: сервер получает или отдает
+		// свою долю ключей в зоне
+		{"add node to zone", nodes[:9], nodes, nodes[9], 1.0 / 4},
+		{"remove node from zone", nodes[:9], nodes[:8], nodes[8], 1.0 / 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := movement(t, p, keys, tt.before, tt.after, 3, tt.node)
+			if !near(got, tt.want, 0.1) {
+				t.Errorf("moved %.3f of keys, want about %.3f", got, tt.want)
+			}
+		})
+	}
+}
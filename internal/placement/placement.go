@@ -0,0 +1,19 @@
+package placement
+
+// Placement определяет, на каких серверах хранения размещается чанк
+type Placement interface {
+	// Rank возвращает серверы из nodes в порядке предпочтения для ключа key
+	// (хеша чанка). Первые серверы списка - основное размещение, остальные -
+	// запасные на случай их недоступности. Для одного ключа и набора серверов
+	// порядок всегда одинаков.
+	Rank(key string, nodes []string) []string
+}
+
+// Choose возвращает count серверов основного размещения ключа
+func Choose(p Placement, key string, nodes []string, count int) []string {
+	ranked := p.Rank(key, nodes)
+	if count < len(ranked) {
+		ranked = ranked[:count]
+	}
+	return ranked
+}
@@ -0,0 +1,80 @@
+package placement
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// testKeys возвращает n ключей, похожих на хеши чанков
+func testKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		sum := sha256.Sum256([]byte(strconv.Itoa(i)))
+		keys[i] = hex.EncodeToString(sum[:])
+	}
+	return keys
+}
+
+// testNodes возвращает адреса n серверов
+func testNodes(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("http://node-%d:9000", i+1)
+	}
+	return nodes
+}
+
+// shares возвращает долю ключей, для которых сервер стоит первым
+func shares(p Placement, keys, nodes []string) map[string]float64 {
+	result := make(map[string]float64)
+	for _, key := range keys {
+		result[p.Rank(key, nodes)[0]] += 1 / float64(len(keys))
+	}
+	return result
+}
+
+// movement возвращает долю ключей, у которых изменились count выбранных
+// серверов при переходе от before к after. Изменение допустимо только для
+// ключей, в размещении которых участвует node - добавленный или удаленный
+// сервер; остальные ключи считаются ошибкой.
+func movement(t *testing.T, p Placement, keys, before, after []string, count int, node string) float64 {
+	t.Helper()
+
+	moved := 0
+	for _, key := range keys {
+		old := Choose(p, key, before, count)
+		cur := Choose(p, key, after, count)
+		slices.Sort(old)
+		slices.Sort(cur)
+		if slices.Equal(old, cur) {
+			continue
+		}
+		moved++
+		if !slices.Contains(old, node) && !slices.Contains(cur, node) {
+			t.Errorf("key %s moved from %v to %v without %s", key[:8], old, cur, node)
+		}
+	}
+	return float64(moved) / float64(len(keys))
+}
+
+// near сообщает, отличается ли got от want не более чем на долю tolerance
+func near(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= want*tolerance
+}
+
+func TestChoose(t *testing.T) {
+	nodes := testNodes(3)
+	p := Rendezvous{}
+
+	if got := Choose(p, "key", nodes, 2); !slices.Equal(got, p.Rank("key", nodes)[:2]) {
+		t.Errorf("Choose(2) = %v, want prefix of %v", got, p.Rank("key", nodes))
+	}
+	if got := Choose(p, "key", nodes, 5); len(got) != 3 {
+		t.Errorf("Choose(5) returned %d nodes, want 3", len(got))
+	}
+}
@@ -0,0 +1,57 @@
+package placement
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// Rendezvous размещение по алгоритму rendezvous hashing (HRW): для каждой
+// пары (ключ, сервер) вычисляется вес, и ключ размещается на серверах
+// с наибольшим весом. При добавлении или удалении сервера перемещаются
+// только ключи, для которых он входит в число выбранных.
+type Rendezvous struct{}
+
+// Rank возвращает серверы по убыванию веса для ключа
+func (Rendezvous) Rank(key string, nodes []string) []string {
+	type scored struct {
+		node  string
+		score uint64
+	}
+
+	list := make([]scored, len(nodes))
+	for i, node := range nodes {
+		list[i] = scored{node: node, score: score(key, node)}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].score != list[j].score {
+			return list[i].score > list[j].score
+		}
+		return list[i].node < list[j].node
+	})
+
+	result := make([]string, len(list))
+	for i, s := range list {
+		result[i] = s.node
+	}
+	return result
+}
+
+// score вычисляет псевдослучайный вес пары (ключ, сервер)
+func score(key, node string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(node))
+	return mix(h.Sum64())
+}
+
+// mix перемешивает биты хеша (финализатор splitmix64): у FNV близкие
+// входные строки дают плохо перемешанные старшие биты
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,73 @@
+package placement
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRendezvousRank(t *testing.T) {
+	nodes := testNodes(5)
+	p := Rendezvous{}
+
+	for _, key := range testKeys(100) {
+		ranked := p.Rank(key, nodes)
+		if len(ranked) != len(nodes) {
+			t.Fatalf("Rank returned %d nodes, want %d", len(ranked), len(nodes))
+		}
+
+		// Порядок не зависит от порядка серверов во входном списке
+		reversed := slices.Clone(nodes)
+		slices.Reverse(reversed)
+		if got := p.Rank(key, reversed); !slices.Equal(got, ranked) {
+			t.Fatalf("Rank depends on input order: %v vs %v", got, ranked)
+		}
+	}
+}
+
+func TestRendezvousBalance(t *testing.T) {
+	nodes := testNodes(8)
+	for node, share := range shares(Rendezvous{}, testKeys(20000), nodes) {
+		if want := 1.0 / float64(len(nodes)); !near(share, want, 0.1) {
+			t.Errorf("node %s gets %.3f of keys, want about %.3f", node, share, want)
+		}
+	}
+}
+
+func TestRendezvousMovement(t *testing.T) {
+	keys := testKeys(20000)
+
+	tests := []struct {
+		name  string
+		nodes int // Число серверов до изменения
+		count int // Число копий
+		add   bool
+	}{
+		{"add 1 of 4", 3, 1, true},
+		{"add 1 of 10", 9, 1, true},
+		{"add 1 of 10 with 3 copies", 9, 3, true},
+		{"remove 1 of 4", 4, 1, false},
+		{"remove 1 of 10", 10, 1, false},
+		{"remove 1 of 10 with 3 copies", 10, 3, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := testNodes(tt.nodes)
+			after := testNodes(tt.nodes + 1)
+			node := after[tt.nodes]
+			total := len(after)
+			if !tt.add {
+				after = before[:tt.nodes-1]
+				node = before[tt.nodes-1]
+				total = len(before)
+			}
+
+			// Перемещаются только ключи, попадающие на изменившийся сервер:
+			// около count/N от всех ключей
+			got := movement(t, Rendezvous{}, keys, before, after, tt.count, node)
+			if want := float64(tt.count) / float64(total); !near(got, want, 0.1) {
+				t.Errorf("moved %.3f of keys, want about %.3f", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package placement
+
+import (
+	"math"
+	"sort"
+)
+
+// Weighted взвешенный rendezvous hashing: доля ключей, попадающих на сервер,
+// пропорциональна его весу. Серверы с нулевым весом не используются.
+// Серверы, для которых вес неизвестен, получают средний вес остальных.
+type Weighted struct {
+	Weights func() map[string]float64 // Текущие веса серверов
+}
+
+// Rank возвращает серверы с положительным весом по убыванию взвешенного
+// показателя для ключа
+func (p Weighted) Rank(key string, nodes []string) []string {
+	weights := p.Weights()
+
+	var sum float64
+	known := 0
+	for _, node := range nodes {
+		if w, ok := weights[node]; ok && w > 0 {
+			sum += w
+			known++
+		}
+	}
+	fallback := 1.0
+	if known > 0 {
+		fallback = sum / float64(known)
+	}
+
+	type scored struct {
+		node  string
+		score float64
+	}
+
+	list := make([]scored, 0, len(nodes))
+	for _, node := range nodes {
+		w, ok := weights[node]
+		if !ok {
+			w = fallback
+		}
+		if w <= 0 {
+			continue
+		}
+
+		// Вес -w/ln(u) с равномерным u из (0, 1): вероятность максимума
+		// у сервера пропорциональна w
+		u := (float64(score(key, node)>>11) + 0.5) / (1 << 53)
+		list = append(list, scored{node: node, score: -w / math.Log(u)})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].score != list[j].score {
+			return list[i].score > list[j].score
+		}
+		return list[i].node < list[j].node
+	})
+
+	result := make([]string, len(list))
+	for i, s := range list {
+		result[i] = s.node
+	}
+	return result
+}
@@ -0,0 +1,114 @@
+package placement
+
+import (
+	"slices"
+	"testing"
+)
+
+// fixedWeights возвращает функцию весов для Weighted
+func fixedWeights(weights map[string]float64) func() map[string]float64 {
+	return func() map[string]float64 { return weights }
+}
+
+func TestWeightedShares(t *testing.T) {
+	nodes := testNodes(4)
+	keys := testKeys(20000)
+
+	tests := []struct {
+		name    string
+		weights map[string]float64
+		want    []float64 // Ожидаемая доля ключей каждого сервера
+	}{
+		{
+			"proportional",
+			map[string]float64{nodes[0]: 1, nodes[1]: 2, nodes[2]: 3, nodes[3]: 4},
+			[]float64{0.1, 0.2, 0.3, 0.4},
+		},
+		{
+			"zero weight excluded",
+			map[string]float64{nodes[0]: 1, nodes[1]: 1, nodes[2]: 2, nodes[3]: 0},
+			[]float64{0.25, 0.25, 0.5, 0},
+		},
+		{
+			"unknown weight gets average",
+			map[string]float64{nodes[0]: 1, nodes[1]: 3},
+			[]float64{1.0 / 8, 3.0 / 8, 2.0 / 8, 2.0 / 8},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shares(Weighted{Weights: fixedWeights(tt.weights)}, keys, nodes)
+			for i, node := range nodes {
+				if !near(got[node], tt.want[i], 0.1) {
+					t.Errorf("node %s gets %.3f of keys, want about %.3f", node, got[node], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedRankSkipsZeroWeight(t *testing.T) {
+	nodes := testNodes(3)
+	p := Weighted{Weights: fixedWeights(map[string]float64{nodes[0]: 1, nodes[1]: 0, nodes[2]: 1})}
+
+	for _, key := range testKeys(100) {
+		if ranked := p.Rank(key, nodes); len(ranked) != 2 || slices.Contains(ranked, nodes[1]) {
+			t.Fatalf("Rank(%s) = %v, want both nodes except %s", key[:8], ranked, nodes[1])
+		}
+	}
+}
+
+func TestWeightedMovement(t *testing.T) {
+	keys := testKeys(20000)
+	nodes := testNodes(6)
+	weights := map[string]float64{
+		nodes[0]: 1, nodes[1]: 2, nodes[2]: 1, nodes[3]: 2, nodes[4]: 1, nodes[5]: 3,
+	}
+	p := Weighted{Weights: fixedWeights(weights)}
+
+	t.Run("add node", func(t *testing.T) {
+		// Новый сервер с весом 3 из суммарных 10 забирает 3/10 ключей
+		got := movement(t, p, keys, nodes[:5], nodes, 1, nodes[5])
+		if want := 3.0 / 10; !near(got, want, 0.1) {
+			t.Errorf("moved %.3f of keys, want about %.3f", got, want)
+		}
+	})
+
+	t.Run("remove node", func(t *testing.T) {
+		// Удаленный сервер с весом 2 из суммарных 10 отдает 2/10 ключей
+		after := slices.Delete(slices.Clone(nodes), 3, 4)
+		got := movement(t, p, keys, nodes, after, 1, nodes[3])
+		if want := 2.0 / 10; !near(got, want, 0.1) {
+			t.Errorf("moved %.3f of keys, want about %.3f", got, want)
+		}
+	})
+
+	t.Run("change weight", func(t *testing.T) {
+		// Изменение веса одного сервера перемещает ключи только на него
+		heavier := make(map[string]float64)
+		for node, w := range weights {
+			heavier[node] = w
+		}
+		heavier[nodes[0]] = 2
+
+		moved := 0
+		for _, key := range keys {
+			old := p.Rank(key, nodes)[0]
+			cur := Weighted{Weights: fixedWeights(heavier)}.Rank(key, nodes)[0]
+			if old == cur {
+				continue
+			}
+			moved++
+			if cur != nodes[0] {
+				t.Fatalf("key %s moved from %s to %s", key[:8], old, cur)
+			}
+		}
+
+		// Доля сервера растет с 1/10 до 2/11
+		got := float64(moved) / float64(len(keys))
+		if want := 2.0/11 - 1.0/10; !near(got, want, 0.2) {
+			t.Errorf("moved %.3f of keys, want about %.3f", got, want)
+		}
+	})
+}
@@ -0,0 +1,253 @@
+package rebalance
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/chunkstate"
+	"github.com/Gammanik/distributed-storage/internal/cluster"
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/utils"
+)
+
+// DrainStatus содержит информацию о ходе вывода сервера из эксплуатации
+type DrainStatus struct {
+	Node        string    `json:"node"`
+	Running     bool      `json:"running"`
+	Removable   bool      `json:"removable"` // На сервере не осталось нужных данных
+	StartedAt   time.Time `json:"startedAt"`
+	FinishedAt  time.Time `json:"finishedAt"`
+	ChunksTotal int       `json:"chunksTotal"` // Чанки с копиями или шардами на сервере
+	ChunksDone  int       `json:"chunksDone"`
+	Moved       int       `json:"moved"`
+	BytesMoved  int64     `json:"bytesMoved"`
+	Failed      int       `json:"failed"`
+	Remaining   int       `json:"remaining"` // Ссылки на сервер в метаданных после переноса
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// Drain запускает в фоне перенос всех копий и шардов с сервера на другие
+// серверы. Сервер должен быть уже исключен из размещения. Если после
+// переноса в метаданных не осталось ссылок на сервер, он помечается
+// в реестре как готовый к удалению. Повторный вызов продолжает перенос.
+// Перенос начинается после завершения текущего прохода перебалансировки.
+func (rb *Rebalancer) Drain(node string) (DrainStatus, error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if st, ok := rb.drains[node]; ok && st.Running {
+		return *st, fmt.Errorf("node %s is already draining", node)
+	}
+	if rb.drains == nil {
+		rb.drains = make(map[string]*DrainStatus)
+	}
+	st := &DrainStatus{Node: node, Running: true, StartedAt: time.Now()}
+	rb.drains[node] = st
+
+	go rb.drain(node)
+	return *st, nil
+}
+
+// ResumeDrains продолжает вывод серверов, прерванный перезапуском: перенос
+// запускается для всех серверов с сохраненным состоянием DrainActive
+func (rb *Rebalancer) ResumeDrains() {
+	for _, n := range rb.StoragePool.Nodes() {
+		if n.Drain != cluster.DrainActive {
+			continue
+		}
+		log.Printf("Resuming drain of storage node %s", n.URL)
+		if _, err := rb.Drain(n.URL); err != nil {
+			log.Printf("Failed to resume drain of %s: %v", n.URL, err)
+		}
+	}
+}
+
+// DrainStatus возвращает ход вывода сервера из эксплуатации
+func (rb *Rebalancer) DrainStatus(node string) (DrainStatus, bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	st, ok := rb.drains[node]
+	if !ok {
+		return DrainStatus{}, false
+	}
+	return *st, true
+}
+
+// updateDrain изменяет статус вывода сервера под блокировкой
+func (rb *Rebalancer) updateDrain(node string, fn func(s *DrainStatus)) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	fn(rb.drains[node])
+}
+
+// drain переносит данные с сервера и проверяет, что от него ничего не зависит.
+// Перенос ждет завершения прохода перебалансировки и вывода других серверов.
+func (rb *Rebalancer) drain(node string) {
+	rb.moving.Lock()
+	defer rb.moving.Unlock()
+
+	err := rb.drainChunks(node)
+
+	remaining, refErr := rb.references(node)
+	if err == nil {
+		err = refErr
+	}
+	if err == nil && remaining == 0 {
+		if _, err = rb.StoragePool.SetDrain(node, cluster.DrainDone); err == nil {
+			log.Printf("Storage node %s is drained and can be removed", node)
+		}
+	}
+
+	rb.updateDrain(node, func(s *DrainStatus) {
+		s.Running = false
+		s.FinishedAt = time.Now()
+		s.Remaining = remaining
+		s.Removable = err == nil && remaining == 0
+		if err != nil {
+			s.LastError = err.Error()
+		} else if remaining > 0 {
+			s.LastError = fmt.Sprintf("%d references to the node remain", remaining)
+		}
+	})
+}
+
+// drainChunks переносит каждую копию и шард с сервера
+func (rb *Rebalancer) drainChunks(node string) error {
+	states, err := rb.collect()
+	if err != nil {
+		return err
+	}
+
+	var held []*chunkstate.State
+	for _, st := range states {
+		if holds(st, node) {
+			held = append(held, st)
+		}
+	}
+	rb.updateDrain(node, func(s *DrainStatus) { s.ChunksTotal = len(held) })
+
+	var limiter <-chan time.Time
+	if rb.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rb.Rate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+	bw := utils.NewThrottle(rb.Bandwidth)
+
+	for _, st := range held {
+		moves := rb.drainMoves(st, node, rb.StoragePool.Available())
+
+		moved, bytes, failed := 0, int64(0), 0
+		if len(moves) == 0 {
+			log.Printf("Failed to drain chunk %s from %s: no storage node available", st.Hash, node)
+			failed = 1
+		} else {
+			if limiter != nil {
+				<-limiter
+			}
+			moved, bytes, failed = rb.apply(st, moves, bw)
+		}
+
+		rb.updateDrain(node, func(s *DrainStatus) {
+			s.ChunksDone++
+			s.Moved += moved
+			s.BytesMoved += bytes
+			s.Failed += failed
+		})
+	}
+	return nil
+}
+
+// drainMoves вычисляет перемещения копии или шардов чанка с сервера node
+// на серверы, где еще нет данных этого чанка
+func (rb *Rebalancer) drainMoves(st *chunkstate.State, node string, available []string) []Move {
+	used := make(map[string]bool)
+	if len(st.Info.Shards) > 0 {
+		for _, si := range st.Info.Shards {
+			used[si.NodeURL] = true
+		}
+	} else {
+		for _, n := range st.Nodes {
+			used[n] = true
+		}
+	}
+
+	var candidates []string
+	for _, n := range rb.Placement.Rank(st.Hash, available) {
+		if !used[n] {
+			candidates = append(candidates, n)
+		}
+	}
+
+	if len(st.Info.Shards) == 0 {
+		if len(candidates) == 0 {
+			return nil
+		}
+		return []Move{{Hash: st.Hash, Shard: -1, ID: st.Hash, From: node, To: candidates[0], Size: st.Info.Size}}
+	}
+
+	var moves []Move
+	size := st.Info.Size
+	if st.Info.DataShards > 0 {
+		size = (size + int64(st.Info.DataShards) - 1) / int64(st.Info.DataShards)
+	}
+	for _, si := range st.Info.Shards {
+		if si.NodeURL != node || len(candidates) == 0 {
+			continue
+		}
+		moves = append(moves, Move{Hash: st.Hash, Shard: si.Index, ID: si.ShardID, From: node, To: candidates[0], Size: size})
+		candidates = candidates[1:]
+	}
+	return moves
+}
+
+// references считает ссылки на сервер в метаданных файлов и записях
+// для дедупликации
+func (rb *Rebalancer) references(node string) (int, error) {
+	count := 0
+	err := rb.Store.ForEachFile(func(meta *metastore.FileMeta) error {
+		for _, infos := range meta.Chunks {
+			for _, ci := range infos {
+				if refers(ci, node) {
+					count++
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = rb.Store.ForEachChunkHash(func(hash string, info metastore.ChunkInfo) error {
+		if refers(info, node) {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// holds проверяет, что на сервере есть копия или шард чанка
+func holds(st *chunkstate.State, node string) bool {
+	if len(st.Info.Shards) > 0 {
+		return refers(st.Info, node)
+	}
+	return slices.Contains(st.Nodes, node)
+}
+
+// refers проверяет, что запись о чанке указывает на сервер
+func refers(ci metastore.ChunkInfo, node string) bool {
+	if ci.NodeURL == node {
+		return true
+	}
+	for _, si := range ci.Shards {
+		if si.NodeURL == node {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,514 @@
+package rebalance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/chunkstate"
+	"github.com/Gammanik/distributed-storage/internal/cluster"
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/placement"
+	"github.com/Gammanik/distributed-storage/internal/storage"
+	"github.com/Gammanik/distributed-storage/internal/utils"
+)
+
+// checkpointName имя сохраненного состояния прохода в метаданных
+const checkpointName = "rebalance"
+
+// Status содержит информацию о ходе перебалансировки
+type Status struct {
+	Running       bool      `json:"running"`
+	Passes        int       `json:"passes"`
+	LastStart     time.Time `json:"lastStart"`
+	LastFinish    time.Time `json:"lastFinish"`
+	Resumed       bool      `json:"resumed"` // Проход продолжен с сохраненной позиции
+	ChunksTotal   int       `json:"chunksTotal"`
+	ChunksChecked int       `json:"chunksChecked"`
+	Moved         int       `json:"moved"` // Перемещенные копии и шарды
+	BytesMoved    int64     `json:"bytesMoved"`
+	Failed        int       `json:"failed"`
+	Checkpoint    string    `json:"checkpoint,omitempty"` // Последний обработанный чанк
+	LastError     string    `json:"lastError,omitempty"`
+}
+
+// Move перемещение одной копии или шарда чанка
+type Move struct {
+	Hash  string `json:"hash"`
+	Shard int    `json:"shard"` // Номер шарда или -1 для копии чанка
+	ID    string `json:"id"`    // Хеш данных на сервере: чанка или шарда
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Size  int64  `json:"size"`
+}
+
+// Plan результат пробного прохода: перемещения, которые выполнил бы проход
+type Plan struct {
+	ChunksTotal  int    `json:"chunksTotal"`
+	ChunksToMove int    `json:"chunksToMove"`
+	TotalMoves   int    `json:"totalMoves"`
+	TotalBytes   int64  `json:"totalBytes"`
+	Moves        []Move `json:"moves"`
+	Truncated    bool   `json:"truncated"` // В Moves попали не все перемещения
+}
+
+// checkpoint сохраняемая позиция прохода
+type checkpoint struct {
+	After      string `json:"after"` // Чанки с хешем не больше After уже обработаны
+	Moved      int    `json:"moved"`
+	BytesMoved int64  `json:"bytesMoved"`
+	Failed     int    `json:"failed"`
+}
+
+// Rebalancer перемещает копии чанков на серверы, выбранные текущим
+// размещением: после добавления серверов на них переезжает их доля данных,
+// а с удаленных и переполненных серверов данные уходят. Каждое перемещение
+// выполняется в порядке: копирование, обновление метаданных, удаление
+// исходной копии. Позиция прохода сохраняется в метаданных, поэтому
+// прерванный проход продолжается с того же места.
+type Rebalancer struct {
+	Store       metastore.MetaStore
+	Storage     storage.Client
+	StoragePool *cluster.Registry
+	Placement   placement.Placement
+	Rate        float64 // Максимум перемещаемых чанков в секунду (0 - без ограничения)
+	Bandwidth   int64   // Максимум копируемых байт в секунду (0 - без ограничения)
+
+	mu     sync.Mutex
+	status Status
+	cancel context.CancelFunc
+	drains map[string]*DrainStatus
+
+	// moving удерживается проходом и выводом сервера на время перемещений:
+	// одновременные перемещения одного чанка перезаписали бы друг друга
+	moving sync.Mutex
+}
+
+// New создает новый Rebalancer
+func New(store metastore.MetaStore, client storage.Client, pool *cluster.Registry) *Rebalancer {
+	return &Rebalancer{
+		Store:       store,
+		Storage:     client,
+		StoragePool: pool,
+		Placement:   placement.Rendezvous{},
+		Rate:        10,
+	}
+}
+
+// Start запускает проход в фоне. Если предыдущий проход был прерван,
+// новый продолжает его.
+func (rb *Rebalancer) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := rb.begin(cancel); err != nil {
+		cancel()
+		return err
+	}
+
+	go func() {
+		defer cancel()
+		err := rb.run(ctx)
+		rb.finish(err)
+		if err != nil {
+			log.Printf("Rebalance pass stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Resume продолжает проход, прерванный остановкой или перезапуском сервера
+func (rb *Rebalancer) Resume() error {
+	cp, err := rb.loadCheckpoint()
+	if err != nil || cp == nil {
+		return err
+	}
+	log.Printf("Resuming rebalance after chunk %s", cp.After)
+	return rb.Start()
+}
+
+// Stop останавливает текущий проход. Позиция сохраняется.
+func (rb *Rebalancer) Stop() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if rb.cancel != nil {
+		rb.cancel()
+	}
+}
+
+// Status возвращает текущее состояние перебалансировки
+func (rb *Rebalancer) Status() Status {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.status
+}
+
+// update изменяет статус под блокировкой
+func (rb *Rebalancer) update(fn func(s *Status)) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	fn(&rb.status)
+}
+
+// Plan вычисляет перемещения без их выполнения. В результат попадает
+// не больше limit перемещений, итоги считаются по всем.
+func (rb *Rebalancer) Plan(limit int) (Plan, error) {
+	states, err := rb.collect()
+	if err != nil {
+		return Plan{}, err
+	}
+
+	plan := Plan{ChunksTotal: len(states), Moves: []Move{}}
+	available := rb.StoragePool.Available()
+	for _, st := range states {
+		moves := rb.movesFor(st, available)
+		if len(moves) == 0 {
+			continue
+		}
+		plan.ChunksToMove++
+		for _, m := range moves {
+			plan.TotalMoves++
+			plan.TotalBytes += m.Size
+			if len(plan.Moves) < limit {
+				plan.Moves = append(plan.Moves, m)
+			} else {
+				plan.Truncated = true
+			}
+		}
+	}
+	return plan, nil
+}
+
+// RunOnce выполняет один проход перебалансировки
+func (rb *Rebalancer) RunOnce(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if err := rb.begin(cancel); err != nil {
+		return err
+	}
+
+	err := rb.run(ctx)
+	rb.finish(err)
+	return err
+}
+
+// begin отмечает начало прохода
+func (rb *Rebalancer) begin(cancel context.CancelFunc) error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.status.Running {
+		return errors.New("rebalance pass already running")
+	}
+	rb.status = Status{Running: true, Passes: rb.status.Passes, LastStart: time.Now()}
+	rb.cancel = cancel
+	return nil
+}
+
+// finish отмечает завершение прохода
+func (rb *Rebalancer) finish(err error) {
+	rb.update(func(s *Status) {
+		s.Running = false
+		s.LastFinish = time.Now()
+		if err == nil {
+			s.Passes++
+		} else {
+			s.LastError = err.Error()
+		}
+	})
+}
+
+// run обходит чанки по возрастанию хеша начиная с сохраненной позиции.
+// Проход ждет завершения вывода серверов из эксплуатации.
+func (rb *Rebalancer) run(ctx context.Context) error {
+	rb.moving.Lock()
+	defer rb.moving.Unlock()
+
+	cp, err := rb.loadCheckpoint()
+	if err != nil {
+		return err
+	}
+	if cp == nil {
+		cp = &checkpoint{}
+	}
+
+	states, err := rb.collect()
+	if err != nil {
+		return err
+	}
+
+	rb.update(func(s *Status) {
+		s.ChunksTotal = len(states)
+		s.Resumed = cp.After != ""
+		s.Moved, s.BytesMoved, s.Failed = cp.Moved, cp.BytesMoved, cp.Failed
+	})
+
+	var limiter <-chan time.Time
+	if rb.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rb.Rate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+	bw := utils.NewThrottle(rb.Bandwidth)
+
+	for i, st := range states {
+		if st.Hash <= cp.After {
+			rb.update(func(s *Status) { s.ChunksChecked++ })
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		moves := rb.movesFor(st, rb.StoragePool.Available())
+		if len(moves) > 0 {
+			if limiter != nil {
+				select {
+				case <-limiter:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+
+			moved, bytes, failed := rb.apply(st, moves, bw)
+			cp.Moved += moved
+			cp.BytesMoved += bytes
+			cp.Failed += failed
+		}
+
+		cp.After = st.Hash
+		rb.update(func(s *Status) {
+			s.ChunksChecked++
+			s.Moved, s.BytesMoved, s.Failed = cp.Moved, cp.BytesMoved, cp.Failed
+			s.Checkpoint = cp.After
+		})
+
+		// Позиция сохраняется после каждого перемещения и периодически
+		// при обходе чанков, которые уже на своих местах
+		if len(moves) > 0 || i%500 == 0 {
+			if err := rb.saveCheckpoint(cp); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Проход завершен: следующий начнется с начала
+	return rb.Store.SaveCheckpoint(checkpointName, nil)
+}
+
+// collect собирает размещение всех чанков по всем файлам, включая
+// незавершенные загрузки: их ссылки тоже нужно перенаправить
+func (rb *Rebalancer) collect() ([]*chunkstate.State, error) {
+	return chunkstate.Collect(rb.Store, false, 0)
+}
+
+// movesFor вычисляет перемещения, приводящие чанк к желаемому размещению
+// на доступных серверах. Число копий и шардов не меняется: недостающие
+// копии восстанавливает repair. Копии на временно недоступных серверах
+// не перемещаются.
+func (rb *Rebalancer) movesFor(st *chunkstate.State, available []string) []Move {
+	if len(st.Info.Shards) > 0 {
+		return rb.shardMoves(st, available)
+	}
+
+	desired := placement.Choose(rb.Placement, st.Hash, available, len(st.Nodes))
+
+	var excess, missing []string
+	for _, node := range st.Nodes {
+		if !slices.Contains(desired, node) && !rb.StoragePool.IsDown(node) {
+			excess = append(excess, node)
+		}
+	}
+	for _, node := range desired {
+		if !slices.Contains(st.Nodes, node) {
+			missing = append(missing, node)
+		}
+	}
+
+	var moves []Move
+	for i := 0; i < len(excess) && i < len(missing); i++ {
+		moves = append(moves, Move{Hash: st.Hash, Shard: -1, ID: st.Hash, From: excess[i], To: missing[i], Size: st.Info.Size})
+	}
+	return moves
+}
+
+// shardMoves вычисляет перемещения шардов чанка с erasure coding. Каждый
+// шард должен находиться на своем сервере из желаемого размещения.
+func (rb *Rebalancer) shardMoves(st *chunkstate.State, available []string) []Move {
+	shards := st.Info.Shards
+	desired := placement.Choose(rb.Placement, st.Hash, available, len(shards))
+
+	held := make(map[string]bool, len(shards))
+	var misplaced []metastore.ShardInfo
+	for _, si := range shards {
+		if slices.Contains(desired, si.NodeURL) && !held[si.NodeURL] {
+			held[si.NodeURL] = true
+			continue
+		}
+		if !rb.StoragePool.IsDown(si.NodeURL) {
+			misplaced = append(misplaced, si)
+		}
+	}
+
+	var free []string
+	for _, node := range desired {
+		if !held[node] {
+			free = append(free, node)
+		}
+	}
+
+	// Размер шарда в метаданных не хранится: все шарды одного размера
+	size := st.Info.Size
+	if st.Info.DataShards > 0 {
+		size = (size + int64(st.Info.DataShards) - 1) / int64(st.Info.DataShards)
+	}
+
+	var moves []Move
+	for i := 0; i < len(misplaced) && i < len(free); i++ {
+		si := misplaced[i]
+		moves = append(moves, Move{Hash: st.Hash, Shard: si.Index, ID: si.ShardID, From: si.NodeURL, To: free[i], Size: size})
+	}
+	return moves
+}
+
+// apply выполняет перемещения одного чанка: копирует данные, записывает
+// новое размещение во все ссылающиеся файлы и удаляет исходные копии.
+// Возвращает число выполненных и неудачных перемещений и объем данных.
+func (rb *Rebalancer) apply(st *chunkstate.State, moves []Move, bw *utils.Throttle) (int, int64, int) {
+	var done []Move
+	var bytes int64
+	failed := 0
+
+	for _, m := range moves {
+		n, err := rb.copy(st, m)
+		if err != nil {
+			log.Printf("Failed to move chunk %s from %s to %s: %v", m.ID, m.From, m.To, err)
+			failed++
+			continue
+		}
+		done = append(done, m)
+		bytes += n
+		bw.Wait(n)
+	}
+	if len(done) == 0 {
+		return 0, 0, failed
+	}
+
+	if err := rb.save(st, done); err != nil {
+		// Метаданные не обновлены: исходные копии остаются на месте,
+		// а новые станут сиротами
+		log.Printf("Failed to update placement of chunk %s: %v", st.Hash, err)
+		return 0, bytes, failed + len(done)
+	}
+
+	for _, m := range done {
+		if err := rb.Storage.DeleteChunk(m.ID, m.From); err != nil {
+			log.Printf("Failed to delete moved chunk %s from %s: %v", m.ID, m.From, err)
+		}
+		log.Printf("Moved chunk %s from %s to %s", m.ID, m.From, m.To)
+	}
+	return len(done), bytes, failed
+}
+
+// copy копирует данные перемещения на новый сервер. Копия чанка берется
+// с исходного сервера, а если он недоступен - с другой копии.
+func (rb *Rebalancer) copy(st *chunkstate.State, m Move) (int64, error) {
+	sources := []string{m.From}
+	if m.Shard < 0 {
+		for _, node := range st.Nodes {
+			if node != m.From {
+				sources = append(sources, node)
+			}
+		}
+	}
+
+	var lastErr error
+	for _, node := range sources {
+		data, err := rb.Storage.DownloadChunk(context.Background(), m.ID, node)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// Сервер хранения сам проверяет, что данные соответствуют хешу
+		if err := rb.Storage.UploadChunk(m.ID, m.To, data); err != nil {
+			return 0, err
+		}
+		return int64(len(data)), nil
+	}
+	return 0, fmt.Errorf("no readable copy: %w", lastErr)
+}
+
+// save записывает размещение после перемещений. Ссылки на чанк и его
+// размещение перечитываются в момент записи: с начала прохода их могли
+// изменить загрузки, восстановление или удаление файлов. В текущем списке
+// заменяются только исходные копии перемещений.
+func (rb *Rebalancer) save(st *chunkstate.State, done []Move) error {
+	return rb.Store.UpdateChunk(st.Hash, st.Refs, func(replicas []metastore.ChunkInfo) []metastore.ChunkInfo {
+		return moveReplicas(replicas, done)
+	})
+}
+
+// moveReplicas возвращает копию списка размещений, в которой копии и шарды
+// на исходных серверах перемещений заменены на новые серверы
+func moveReplicas(replicas []metastore.ChunkInfo, done []Move) []metastore.ChunkInfo {
+	result := make([]metastore.ChunkInfo, 0, len(replicas))
+	for _, ci := range replicas {
+		if len(ci.Shards) > 0 {
+			ci.Shards = append([]metastore.ShardInfo(nil), ci.Shards...)
+			for _, m := range done {
+				for i := range ci.Shards {
+					if ci.Shards[i].Index == m.Shard && ci.Shards[i].NodeURL == m.From {
+						ci.Shards[i].NodeURL = m.To
+					}
+				}
+			}
+			result = append(result, ci)
+			continue
+		}
+
+		for _, m := range done {
+			if m.Shard < 0 && ci.NodeURL == m.From {
+				ci.NodeURL = m.To
+			}
+		}
+		if !hasNode(result, ci.NodeURL) {
+			result = append(result, ci)
+		}
+	}
+	return result
+}
+
+// hasNode проверяет, есть ли в списке копия на сервере node
+func hasNode(replicas []metastore.ChunkInfo, node string) bool {
+	for _, ci := range replicas {
+		if len(ci.Shards) == 0 && ci.NodeURL == node {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCheckpoint возвращает сохраненную позицию прохода или nil
+func (rb *Rebalancer) loadCheckpoint() (*checkpoint, error) {
+	data, err := rb.Store.LoadCheckpoint(checkpointName)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// saveCheckpoint сохраняет позицию прохода
+func (rb *Rebalancer) saveCheckpoint(cp *checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return rb.Store.SaveCheckpoint(checkpointName, data)
+}
@@ -0,0 +1,428 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/cluster"
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/storage"
+)
+
+// orphansCheckpoint имя сохраненного списка сирот в метаданных
+const orphansCheckpoint = "orphans"
+
+// maxSamples максимум чанков каждого вида, перечисляемых в отчете
+const maxSamples = 100
+
+// Status содержит информацию о работе сверки
+type Status struct {
+	Running   bool    `json:"running"`
+	Passes    int     `json:"passes"`
+	Report    *Report `json:"report,omitempty"` // Отчет последнего завершенного прохода
+	LastError string  `json:"lastError,omitempty"`
+}
+
+// Report отчет одного прохода сверки
+type Report struct {
+	Start     time.Time    `json:"start"`
+	Finish    time.Time    `json:"finish"`
+	Nodes     []NodeReport `json:"nodes"`
+	Missing   int          `json:"missing"`   // Копии из метаданных, которых нет на серверах
+	Stray     int          `json:"stray"`     // Лишние копии известных чанков
+	Orphans   int          `json:"orphans"`   // Чанки, неизвестные метаданным
+	Collected int          `json:"collected"` // Сироты, переданные сборщику мусора
+	Repair    int          `json:"repair"`    // Чанки, поставленные в очередь на восстановление
+
+	MissingChunks []Location `json:"missingChunks,omitempty"`
+	OrphanChunks  []Location `json:"orphanChunks,omitempty"`
+}
+
+// NodeReport результат сверки одного сервера хранения
+type NodeReport struct {
+	Node     string `json:"node"`
+	Chunks   int    `json:"chunks"` // Чанки и шарды на сервере
+	Bytes    int64  `json:"bytes"`
+	Expected int    `json:"expected"` // Чанки и шарды, которые должны быть на сервере
+	Missing  int    `json:"missing"`
+	Stray    int    `json:"stray"`
+	Orphans  int    `json:"orphans"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Location чанк или шард на сервере хранения
+type Location struct {
+	Node string `json:"node"`
+	ID   string `json:"id"`             // Хеш данных на сервере: чанка или шарда
+	Hash string `json:"hash,omitempty"` // Хеш чанка, к которому относится шард
+}
+
+// orphan сирота, найденная на сервере хранения
+type orphan struct {
+	Node      string    `json:"node"`
+	ID        string    `json:"id"`
+	Size      int64     `json:"size"`
+	FirstSeen time.Time `json:"firstSeen"`
+}
+
+// Reconciler сверяет списки чанков серверов хранения с размещением из
+// метаданных. Отсутствующие на серверах чанки передаются на восстановление.
+// Чанки, о которых метаданные ничего не знают, считаются сиротами и после
+// Grace передаются сборщику мусора: это время нужно, чтобы не удалить чанки
+// загрузок, метаданные которых еще не записаны. Лишние копии известных
+// чанков только попадают в отчет.
+type Reconciler struct {
+	Store       metastore.MetaStore
+	Storage     storage.Client
+	StoragePool *cluster.Registry
+	Interval    time.Duration // Период между проходами
+	Grace       time.Duration // Время, после которого сирота удаляется
+
+	OnMissing func(hashes ...string) // Вызывается с чанками, которым нужно восстановление
+	OnOrphans func()                 // Вызывается после передачи сирот в очередь на удаление
+
+	mu      sync.Mutex
+	status  Status
+	trigger chan struct{}
+}
+
+// New создает новый Reconciler
+func New(store metastore.MetaStore, client storage.Client, pool *cluster.Registry) *Reconciler {
+	return &Reconciler{
+		Store:       store,
+		Storage:     client,
+		StoragePool: pool,
+		Interval:    time.Hour,
+		Grace:       24 * time.Hour,
+		trigger:     make(chan struct{}, 1),
+	}
+}
+
+// Run запускает периодические проходы сверки до отмены контекста.
+// Если Interval <= 0, проходы выполняются только по Trigger.
+func (rc *Reconciler) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if rc.Interval > 0 {
+		ticker := time.NewTicker(rc.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-rc.trigger:
+		}
+
+		if err := rc.RunOnce(ctx); err != nil {
+			log.Printf("Reconcile pass failed: %v", err)
+		}
+	}
+}
+
+// Trigger запрашивает внеочередной проход сверки
+func (rc *Reconciler) Trigger() {
+	select {
+	case rc.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Status возвращает текущее состояние сверки
+func (rc *Reconciler) Status() Status {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.status
+}
+
+// RunOnce выполняет один проход сверки
+func (rc *Reconciler) RunOnce(ctx context.Context) error {
+	rc.mu.Lock()
+	if rc.status.Running {
+		rc.mu.Unlock()
+		return fmt.Errorf("reconcile pass already running")
+	}
+	rc.status.Running = true
+	rc.status.LastError = ""
+	rc.mu.Unlock()
+
+	report, err := rc.reconcile(ctx)
+
+	rc.mu.Lock()
+	rc.status.Running = false
+	rc.status.Passes++
+	if err != nil {
+		rc.status.LastError = err.Error()
+	} else {
+		rc.status.Report = report
+	}
+	rc.mu.Unlock()
+
+	if err == nil {
+		log.Printf("Reconcile pass finished: %d missing, %d stray, %d orphans, %d collected",
+			report.Missing, report.Stray, report.Orphans, report.Collected)
+	}
+	return err
+}
+
+// expected ожидаемое размещение данных по метаданным
+type expected struct {
+	nodes map[string]map[string]string // Сервер -> ID данных -> хеш чанка
+	known map[string]bool              // Все ID данных, известные метаданным
+}
+
+// reconcile сверяет все доступные серверы и обрабатывает расхождения
+func (rc *Reconciler) reconcile(ctx context.Context) (*Report, error) {
+	report := &Report{Start: time.Now()}
+
+	// Метаданные читаются до списков серверов: чанк, записанный во время
+	// прохода, в худшем случае окажется сиротой и переживет Grace
+	exp, err := rc.expected()
+	if err != nil {
+		return nil, err
+	}
+
+	orphans, err := rc.loadOrphans()
+	if err != nil {
+		return nil, err
+	}
+
+	repair := make(map[string]bool)
+	for _, node := range rc.StoragePool.Nodes() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if node.State == cluster.StateDown {
+			report.Nodes = append(report.Nodes, NodeReport{Node: node.URL, Error: "node is down"})
+			continue
+		}
+
+		nr := rc.reconcileNode(node.URL, exp, orphans, repair, report)
+		report.Nodes = append(report.Nodes, nr)
+	}
+
+	// Сироты, пережившие Grace, передаются сборщику мусора
+	garbage := make(map[string][]metastore.ChunkInfo)
+	for key, o := range orphans {
+		if report.Start.Sub(o.FirstSeen) < rc.Grace {
+			continue
+		}
+		garbage[o.ID] = append(garbage[o.ID], metastore.ChunkInfo{ChunkID: o.ID, NodeURL: o.Node, Size: o.Size})
+		delete(orphans, key)
+	}
+	for id, locations := range garbage {
+		if err := rc.Store.SetGarbage(id, locations); err != nil {
+			return nil, err
+		}
+		for _, ci := range locations {
+			log.Printf("Orphan chunk %s on %s queued for deletion", id, ci.NodeURL)
+		}
+		report.Collected += len(locations)
+	}
+
+	if err := rc.saveOrphans(orphans); err != nil {
+		return nil, err
+	}
+
+	if len(repair) > 0 && rc.OnMissing != nil {
+		hashes := make([]string, 0, len(repair))
+		for hash := range repair {
+			hashes = append(hashes, hash)
+		}
+		sort.Strings(hashes)
+		rc.OnMissing(hashes...)
+	}
+	report.Repair = len(repair)
+	if report.Collected > 0 && rc.OnOrphans != nil {
+		rc.OnOrphans()
+	}
+
+	report.Finish = time.Now()
+	return report, nil
+}
+
+// reconcileNode сравнивает список чанков сервера с ожидаемым размещением
+func (rc *Reconciler) reconcileNode(node string, exp *expected, orphans map[string]orphan, repair map[string]bool, report *Report) NodeReport {
+	want := exp.nodes[node]
+	nr := NodeReport{Node: node, Expected: len(want)}
+
+	present := make(map[string]bool, len(want))
+	seen := make(map[string]bool)
+	err := rc.Storage.StreamChunks(node, "", func(e storage.ChunkEntry) error {
+		nr.Chunks++
+		nr.Bytes += e.Size
+
+		if _, ok := want[e.ID]; ok {
+			present[e.ID] = true
+			return nil
+		}
+		if exp.known[e.ID] {
+			nr.Stray++
+			return nil
+		}
+
+		// Чанк неизвестен метаданным
+		nr.Orphans++
+		key := node + " " + e.ID
+		seen[key] = true
+		if _, ok := orphans[key]; !ok {
+			orphans[key] = orphan{Node: node, ID: e.ID, Size: e.Size, FirstSeen: report.Start}
+		}
+		if len(report.OrphanChunks) < maxSamples {
+			report.OrphanChunks = append(report.OrphanChunks, Location{Node: node, ID: e.ID})
+		}
+		return nil
+	})
+	if err != nil {
+		// Неполный список не позволяет судить ни о пропавших чанках, ни о сиротах
+		nr.Error = err.Error()
+		for key := range seen {
+			if orphans[key].FirstSeen.Equal(report.Start) {
+				delete(orphans, key)
+			}
+		}
+		log.Printf("Failed to list chunks on %s: %v", node, err)
+		return NodeReport{Node: node, Expected: len(want), Error: nr.Error}
+	}
+
+	// Сироты, которых больше нет на сервере или которые стали известны
+	for key, o := range orphans {
+		if o.Node == node && !seen[key] {
+			delete(orphans, key)
+		}
+	}
+
+	ids := make([]string, 0, len(want))
+	for id := range want {
+		if !present[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		hash := want[id]
+		repair[hash] = true
+		nr.Missing++
+		if len(report.MissingChunks) < maxSamples {
+			loc := Location{Node: node, ID: id}
+			if hash != id {
+				loc.Hash = hash
+			}
+			report.MissingChunks = append(report.MissingChunks, loc)
+		}
+	}
+
+	report.Missing += nr.Missing
+	report.Stray += nr.Stray
+	report.Orphans += nr.Orphans
+	return nr
+}
+
+// expected собирает ожидаемое размещение чанков и шардов по метаданным.
+// На серверах ожидаются чанки завершенных файлов. Известными считаются
+// также чанки незавершенных загрузок и чанки в очереди на удаление.
+func (rc *Reconciler) expected() (*expected, error) {
+	exp := &expected{
+		nodes: make(map[string]map[string]string),
+		known: make(map[string]bool),
+	}
+
+	expect := func(node, id, hash string) {
+		if node == "" {
+			return
+		}
+		if exp.nodes[node] == nil {
+			exp.nodes[node] = make(map[string]string)
+		}
+		exp.nodes[node][id] = hash
+	}
+
+	err := rc.Store.ForEachFile(func(meta *metastore.FileMeta) error {
+		for _, infos := range meta.Chunks {
+			for _, ci := range infos {
+				exp.known[ci.ChunkID] = true
+				if meta.Complete && len(ci.Shards) == 0 {
+					expect(ci.NodeURL, ci.ChunkID, ci.ChunkID)
+				}
+				for _, si := range ci.Shards {
+					exp.known[si.ShardID] = true
+					if meta.Complete {
+						expect(si.NodeURL, si.ShardID, ci.ChunkID)
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = rc.Store.ForEachChunkHash(func(hash string, info metastore.ChunkInfo) error {
+		exp.known[hash] = true
+		for _, si := range info.Shards {
+			exp.known[si.ShardID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = rc.Store.ForEachGarbage(func(hash string, locations []metastore.ChunkInfo) error {
+		exp.known[hash] = true
+		for _, ci := range locations {
+			for _, si := range ci.Shards {
+				exp.known[si.ShardID] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return exp, nil
+}
+
+// loadOrphans читает сирот, найденных прошлыми проходами
+func (rc *Reconciler) loadOrphans() (map[string]orphan, error) {
+	orphans := make(map[string]orphan)
+	data, err := rc.Store.LoadCheckpoint(orphansCheckpoint)
+	if err != nil || data == nil {
+		return orphans, err
+	}
+
+	var list []orphan
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid orphan list: %w", err)
+	}
+	for _, o := range list {
+		orphans[o.Node+" "+o.ID] = o
+	}
+	return orphans, nil
+}
+
+// saveOrphans сохраняет сирот до следующего прохода
+func (rc *Reconciler) saveOrphans(orphans map[string]orphan) error {
+	if len(orphans) == 0 {
+		return rc.Store.SaveCheckpoint(orphansCheckpoint, nil)
+	}
+
+	list := make([]orphan, 0, len(orphans))
+	for _, o := range orphans {
+		list = append(list, o)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return rc.Store.SaveCheckpoint(orphansCheckpoint, data)
+}
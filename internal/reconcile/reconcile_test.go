@@ -0,0 +1,185 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/cluster"
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/storage"
+)
+
+// fakeInventory отдает заранее заданные списки чанков серверов
+type fakeInventory struct {
+	chunks map[string][]string // Сервер -> ID чанков по возрастанию
+	errs   map[string]error    // Ошибка списка сервера после выдачи всех чанков
+}
+
+func (f *fakeInventory) StreamChunks(nodeURL, after string, fn func(e storage.ChunkEntry) error) error {
+	for _, id := range f.chunks[nodeURL] {
+		if id <= after {
+			continue
+		}
+		if err := fn(storage.ChunkEntry{ID: id, Size: 10}); err != nil {
+			return err
+		}
+	}
+	return f.errs[nodeURL]
+}
+
+func (f *fakeInventory) UploadChunk(chunkID, nodeURL string, data []byte) error { return nil }
+func (f *fakeInventory) DownloadChunk(ctx context.Context, chunkID, nodeURL string) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+func (f *fakeInventory) DownloadChunkRange(chunkID, nodeURL string, off, length int64) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+func (f *fakeInventory) HasChunk(chunkID, nodeURL string) (bool, error) { return false, nil }
+func (f *fakeInventory) DeleteChunk(chunkID, nodeURL string) error      { return nil }
+func (f *fakeInventory) ListChunks(nodeURL, after string, limit int) ([]storage.ChunkEntry, string, error) {
+	return nil, "", errors.New("not implemented")
+}
+
+// newTestReconciler создает Reconciler с метаданными во временной директории:
+// завершенный файл с чанком c1 на a и b и чанком c2 на a, незавершенная
+// загрузка с чанком c3 на b
+func newTestReconciler(t *testing.T, inv *fakeInventory) (*Reconciler, *metastore.BoltStore) {
+	t.Helper()
+
+	store, err := metastore.NewBoltStore(filepath.Join(t.TempDir(), "meta.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	save := func(fileID string, index int, hash, node string) {
+		t.Helper()
+		if err := store.SaveChunk(fileID, index, metastore.ChunkInfo{ChunkID: hash, NodeURL: node, Size: 10}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"file", "upload"} {
+		if err := store.InitFile(id, id+".bin", 0, "fixed", metastore.DurabilityPolicy{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save("file", 0, "c1", "http://a")
+	save("file", 0, "c1", "http://b")
+	save("file", 1, "c2", "http://a")
+	if err := store.MarkComplete("file"); err != nil {
+		t.Fatal(err)
+	}
+	save("upload", 0, "c3", "http://b")
+
+	return New(store, inv, cluster.New([]string{"http://a", "http://b"})), store
+}
+
+func TestReconcileClassifies(t *testing.T) {
+	inv := &fakeInventory{chunks: map[string][]string{
+		"http://a": {"c1", "orphan"},   // c2 пропал
+		"http://b": {"c1", "c2", "c3"}, // c2 - лишняя копия, c3 - чанк загрузки
+	}}
+	rc, _ := newTestReconciler(t, inv)
+
+	var repaired []string
+	rc.OnMissing = func(hashes ...string) { repaired = append(repaired, hashes...) }
+
+	if err := rc.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	report := rc.Status().Report
+
+	if report.Missing != 1 || report.Stray != 2 || report.Orphans != 1 || report.Collected != 0 {
+		t.Fatalf("report = %d missing, %d stray, %d orphans, %d collected; want 1, 2, 1, 0",
+			report.Missing, report.Stray, report.Orphans, report.Collected)
+	}
+	if !slices.Equal(repaired, []string{"c2"}) {
+		t.Errorf("queued for repair %v, want [c2]", repaired)
+	}
+	if want := []Location{{Node: "http://a", ID: "c2"}}; !slices.Equal(report.MissingChunks, want) {
+		t.Errorf("missing chunks = %v, want %v", report.MissingChunks, want)
+	}
+	if want := []Location{{Node: "http://a", ID: "orphan"}}; !slices.Equal(report.OrphanChunks, want) {
+		t.Errorf("orphan chunks = %v, want %v", report.OrphanChunks, want)
+	}
+}
+
+// garbage возвращает чанки в очереди на удаление
+func garbage(t *testing.T, store metastore.MetaStore) map[string][]metastore.ChunkInfo {
+	t.Helper()
+
+	result := make(map[string][]metastore.ChunkInfo)
+	err := store.ForEachGarbage(func(hash string, locations []metastore.ChunkInfo) error {
+		result[hash] = locations
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestReconcileOrphanGrace(t *testing.T) {
+	inv := &fakeInventory{chunks: map[string][]string{
+		"http://a": {"c1", "c2", "orphan", "short-lived"},
+		"http://b": {"c1"},
+	}}
+	rc, store := newTestReconciler(t, inv)
+	rc.Grace = 50 * time.Millisecond
+	collected := 0
+	rc.OnOrphans = func() { collected++ }
+
+	// Новые сироты не удаляются до истечения Grace
+	if err := rc.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := garbage(t, store); len(got) != 0 {
+		t.Fatalf("orphans queued before grace: %v", got)
+	}
+
+	// Сирота, исчезнувшая с сервера, забывается
+	inv.chunks["http://a"] = []string{"c1", "c2", "orphan"}
+	time.Sleep(60 * time.Millisecond)
+	if err := rc.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := garbage(t, store)
+	if len(got) != 1 || len(got["orphan"]) != 1 || got["orphan"][0].NodeURL != "http://a" {
+		t.Fatalf("garbage = %v, want orphan on http://a", got)
+	}
+	if report := rc.Status().Report; report.Collected != 1 || collected != 1 {
+		t.Errorf("collected = %d, OnOrphans calls = %d, want 1 and 1", report.Collected, collected)
+	}
+	if orphans, err := rc.loadOrphans(); err != nil || len(orphans) != 0 {
+		t.Errorf("remembered orphans = %v, %v, want none", orphans, err)
+	}
+}
+
+func TestReconcileListingError(t *testing.T) {
+	inv := &fakeInventory{
+		chunks: map[string][]string{"http://a": {"c1", "orphan"}, "http://b": {"c1"}},
+		errs:   map[string]error{"http://a": errors.New("listing truncated")},
+	}
+	rc, _ := newTestReconciler(t, inv)
+	rc.OnMissing = func(hashes ...string) { t.Errorf("repair requested for %v from an incomplete listing", hashes) }
+
+	if err := rc.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	report := rc.Status().Report
+	if report.Missing != 0 || report.Orphans != 0 {
+		t.Errorf("report = %d missing, %d orphans; want none from an incomplete listing", report.Missing, report.Orphans)
+	}
+	if report.Nodes[0].Error == "" {
+		t.Error("listing error is not reported")
+	}
+	if orphans, err := rc.loadOrphans(); err != nil || len(orphans) != 0 {
+		t.Errorf("remembered orphans = %v, %v, want none", orphans, err)
+	}
+}
@@ -0,0 +1,573 @@
+package repair
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Gammanik/distributed-storage/internal/chunkstate"
+	"github.com/Gammanik/distributed-storage/internal/cluster"
+	"github.com/Gammanik/distributed-storage/internal/erasure"
+	"github.com/Gammanik/distributed-storage/internal/metastore"
+	"github.com/Gammanik/distributed-storage/internal/placement"
+	"github.com/Gammanik/distributed-storage/internal/storage"
+	"github.com/Gammanik/distributed-storage/internal/utils"
+)
+
+// Status содержит информацию о ходе восстановления
+type Status struct {
+	Running         bool      `json:"running"`
+	Passes          int       `json:"passes"`
+	LastStart       time.Time `json:"lastStart"`
+	LastFinish      time.Time `json:"lastFinish"`
+	ChunksTotal     int       `json:"chunksTotal"`
+	ChunksChecked   int       `json:"chunksChecked"`
+	UnderReplicated int       `json:"underReplicated"`
+	Repaired        int       `json:"repaired"`
+	Failed          int       `json:"failed"`
+	SpreadViolated  int       `json:"spreadViolated"` // Чанки, копии которых не разнесены по failure domain
+	Queued          int       `json:"queued"`         // Чанки, ожидающие внеочередной проверки
+	LastError       string    `json:"lastError,omitempty"`
+}
+
+// Repairer фоновый процесс, который находит чанки с недостаточным числом
+// копий (или шардов) и восстанавливает их на других серверах хранения
+type Repairer struct {
+	Store       metastore.MetaStore
+	Storage     storage.Client
+	StoragePool *cluster.Registry
+	Placement   placement.Placement // Выбор серверов для новых копий
+	DomainLevel string              // Уровень failure domain, по которому разносятся копии
+	Replicas    int                 // Целевое число копий для файлов без политики
+	Interval    time.Duration       // Период между проходами
+	Rate        float64             // Максимум восстанавливаемых чанков в секунду (0 - без ограничения)
+
+	mu      sync.Mutex
+	status  Status
+	queue   map[string]bool // Чанки для внеочередной проверки
+	trigger chan struct{}
+	queued  chan struct{}
+}
+
+// New создает новый Repairer
+func New(store metastore.MetaStore, client storage.Client, pool *cluster.Registry) *Repairer {
+	return &Repairer{
+		Store:       store,
+		Storage:     client,
+		StoragePool: pool,
+		Placement:   placement.Rendezvous{},
+		DomainLevel: placement.LevelHost,
+		Replicas:    2,
+		Interval:    10 * time.Minute,
+		queue:       make(map[string]bool),
+		trigger:     make(chan struct{}, 1),
+		queued:      make(chan struct{}, 1),
+	}
+}
+
+// Run запускает периодические проходы восстановления до отмены контекста.
+// Если Interval <= 0, проходы выполняются только по Trigger.
+// Чанки из очереди Enqueue проверяются отдельными короткими проходами.
+func (rp *Repairer) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if rp.Interval > 0 {
+		ticker := time.NewTicker(rp.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-rp.trigger:
+		case <-rp.queued:
+			if err := rp.RunQueued(ctx); err != nil {
+				log.Printf("Repair pass failed: %v", err)
+			}
+			continue
+		}
+
+		if err := rp.RunOnce(ctx); err != nil {
+			log.Printf("Repair pass failed: %v", err)
+		}
+	}
+}
+
+// Trigger запрашивает внеочередной проход восстановления
+func (rp *Repairer) Trigger() {
+	select {
+	case rp.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue ставит чанки в очередь на внеочередную проверку и восстановление.
+// Вместо хеша чанка можно передать хеш его шарда.
+func (rp *Repairer) Enqueue(hashes ...string) {
+	if len(hashes) == 0 {
+		return
+	}
+
+	rp.mu.Lock()
+	for _, hash := range hashes {
+		rp.queue[hash] = true
+	}
+	rp.status.Queued = len(rp.queue)
+	rp.mu.Unlock()
+
+	select {
+	case rp.queued <- struct{}{}:
+	default:
+	}
+}
+
+// Status возвращает текущее состояние восстановления
+func (rp *Repairer) Status() Status {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	return rp.status
+}
+
+// RunOnce выполняет один полный проход восстановления.
+// Полный проход проверяет и чанки из очереди, поэтому очередь очищается.
+func (rp *Repairer) RunOnce(ctx context.Context) error {
+	return rp.pass(ctx, false)
+}
+
+// RunQueued выполняет проход только по чанкам из очереди Enqueue
+func (rp *Repairer) RunQueued(ctx context.Context) error {
+	return rp.pass(ctx, true)
+}
+
+// pass выполняет проход восстановления по всем чанкам или только по очереди
+func (rp *Repairer) pass(ctx context.Context, queuedOnly bool) error {
+	rp.mu.Lock()
+	if rp.status.Running {
+		rp.mu.Unlock()
+		return fmt.Errorf("repair pass already running")
+	}
+	queue := rp.queue
+	if queuedOnly && len(queue) == 0 {
+		rp.mu.Unlock()
+		return nil
+	}
+	rp.queue = make(map[string]bool)
+	rp.status = Status{Running: true, Passes: rp.status.Passes, LastStart: time.Now()}
+	rp.mu.Unlock()
+
+	states, err := rp.collect()
+	if err == nil && queuedOnly {
+		selected := states[:0]
+		for _, st := range states {
+			if queued(st, queue) {
+				selected = append(selected, st)
+			}
+		}
+		states = selected
+	}
+	if err != nil {
+		// Очередь понадобится следующему проходу
+		rp.Enqueue(keys(queue)...)
+	}
+
+	rp.update(func(s *Status) {
+		s.ChunksTotal = len(states)
+		if err != nil {
+			s.LastError = err.Error()
+		}
+	})
+
+	if err == nil {
+		err = rp.repairAll(ctx, states)
+	}
+
+	rp.update(func(s *Status) {
+		s.Running = false
+		s.Passes++
+		s.LastFinish = time.Now()
+		if err != nil {
+			s.LastError = err.Error()
+		}
+	})
+
+	return err
+}
+
+// update изменяет статус под мьютексом
+func (rp *Repairer) update(fn func(s *Status)) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	fn(&rp.status)
+}
+
+// collect собирает информацию о размещении всех чанков завершенных файлов
+func (rp *Repairer) collect() ([]*chunkstate.State, error) {
+	states, err := chunkstate.Collect(rp.Store, true, rp.Replicas)
+	if err != nil {
+		return nil, err
+	}
+
+	byHash := make(map[string]*chunkstate.State, len(states))
+	for _, st := range states {
+		byHash[st.Hash] = st
+	}
+
+	// Запись в бакете chunks тоже указывает на известную копию
+	err = rp.Store.ForEachChunkHash(func(hash string, info metastore.ChunkInfo) error {
+		if st, ok := byHash[hash]; ok {
+			st.AddNode(info.NodeURL)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
+
+// repairAll проверяет и при необходимости восстанавливает каждый чанк
+func (rp *Repairer) repairAll(ctx context.Context, states []*chunkstate.State) error {
+	var limiter <-chan time.Time
+	if rp.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rp.Rate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	for _, st := range states {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		violated := rp.spreadViolated(st)
+
+		var (
+			needed bool
+			err    error
+		)
+		if len(st.Info.Shards) > 0 {
+			needed, err = rp.repairShards(st, limiter)
+		} else {
+			needed, err = rp.repairReplicas(st, limiter)
+		}
+
+		rp.update(func(s *Status) {
+			s.ChunksChecked++
+			if violated {
+				s.SpreadViolated++
+			}
+			if needed {
+				s.UnderReplicated++
+				if err != nil {
+					s.Failed++
+				} else {
+					s.Repaired++
+				}
+			}
+		})
+
+		if err != nil {
+			log.Printf("Failed to repair chunk %s: %v", st.Hash, err)
+		}
+	}
+
+	return nil
+}
+
+// repairReplicas восстанавливает недостающие копии реплицированного чанка.
+// Возвращает true, если чанку требовалось восстановление.
+func (rp *Repairer) repairReplicas(st *chunkstate.State, limiter <-chan time.Time) (bool, error) {
+	var healthy, kept, lost, added []string
+	for _, node := range st.Nodes {
+		ok, err := rp.Storage.HasChunk(st.Hash, node)
+		if err != nil {
+			// Сервер недоступен: копия не считается живой, но запись сохраняется
+			log.Printf("Failed to check chunk %s on %s: %v", st.Hash, node, err)
+			kept = append(kept, node)
+			continue
+		}
+		if ok {
+			healthy = append(healthy, node)
+			kept = append(kept, node)
+		} else {
+			lost = append(lost, node)
+		}
+	}
+
+	needed := len(healthy) < st.Target
+
+	if needed {
+		if len(healthy) == 0 {
+			return true, fmt.Errorf("no healthy replicas")
+		}
+		if limiter != nil {
+			<-limiter
+		}
+
+		data, err := rp.download(st.Hash, healthy)
+		if err != nil {
+			return true, err
+		}
+
+		for _, node := range rp.candidates(st.Hash, kept) {
+			if len(healthy) >= st.Target {
+				break
+			}
+			if err := rp.Storage.UploadChunk(st.Hash, node, data); err != nil {
+				log.Printf("Failed to copy chunk %s to %s: %v", st.Hash, node, err)
+				continue
+			}
+			log.Printf("Repaired chunk %s: new replica on %s", st.Hash, node)
+			healthy = append(healthy, node)
+			kept = append(kept, node)
+			added = append(added, node)
+		}
+	}
+
+	if len(lost) > 0 || len(added) > 0 {
+		err := rp.save(st, func(replicas []metastore.ChunkInfo) []metastore.ChunkInfo {
+			return replaceReplicas(replicas, lost, added, st.Hash, st.Info.Size)
+		})
+		if err != nil {
+			return needed, err
+		}
+	}
+
+	if needed && len(healthy) < st.Target {
+		return true, fmt.Errorf("only %d of %d replicas available", len(healthy), st.Target)
+	}
+	return needed, nil
+}
+
+// repairShards восстанавливает потерянные шарды чанка с erasure coding.
+// Возвращает true, если чанку требовалось восстановление.
+func (rp *Repairer) repairShards(st *chunkstate.State, limiter <-chan time.Time) (bool, error) {
+	ci := st.Info
+	var (
+		missing []int
+		used    []string // Серверы с исправными шардами
+	)
+	for i, si := range ci.Shards {
+		ok, err := rp.Storage.HasChunk(si.ShardID, si.NodeURL)
+		if err != nil || !ok {
+			missing = append(missing, i)
+			continue
+		}
+		used = append(used, si.NodeURL)
+	}
+	if len(missing) == 0 {
+		return false, nil
+	}
+	if limiter != nil {
+		<-limiter
+	}
+
+	enc, err := erasure.New(ci.DataShards, len(ci.Shards)-ci.DataShards)
+	if err != nil {
+		return true, err
+	}
+
+	// Скачиваем любые DataShards доступных шардов
+	shards := make([][]byte, len(ci.Shards))
+	have := 0
+	for _, si := range ci.Shards {
+		if have == ci.DataShards {
+			break
+		}
+		data, err := rp.Storage.DownloadChunk(context.Background(), si.ShardID, si.NodeURL)
+		if err != nil || utils.CalculateSHA256(data) != si.ShardID {
+			continue
+		}
+		if data, err = erasure.UnwrapShard(ci.ChunkID, si.Index, data); err != nil {
+			continue
+		}
+		shards[si.Index] = data
+		have++
+	}
+	if err := enc.Reconstruct(shards); err != nil {
+		return true, err
+	}
+
+	// Каждый восстановленный шард отправляется на сервер, где еще нет шардов чанка
+	moved := make(map[int][2]string) // Номер шарда -> прежний и новый сервер
+	candidates := rp.candidates(st.Hash, used)
+	for _, i := range missing {
+		si := ci.Shards[i]
+		data := erasure.WrapShard(ci.ChunkID, si.Index, shards[si.Index])
+		placed := false
+		for len(candidates) > 0 && !placed {
+			node := candidates[0]
+			candidates = candidates[1:]
+			if err := rp.Storage.UploadChunk(si.ShardID, node, data); err != nil {
+				log.Printf("Failed to copy shard %s to %s: %v", si.ShardID, node, err)
+				continue
+			}
+			log.Printf("Repaired chunk %s: shard %d moved to %s", st.Hash, si.Index, node)
+			moved[si.Index] = [2]string{si.NodeURL, node}
+			placed = true
+		}
+		if !placed {
+			err = fmt.Errorf("no storage node available for shard %d", si.Index)
+		}
+	}
+
+	if len(moved) > 0 {
+		saveErr := rp.save(st, func(replicas []metastore.ChunkInfo) []metastore.ChunkInfo {
+			return replaceShards(replicas, moved)
+		})
+		if saveErr != nil {
+			return true, saveErr
+		}
+	}
+
+	return true, err
+}
+
+// download скачивает чанк с первой исправной копии
+func (rp *Repairer) download(hash string, nodes []string) ([]byte, error) {
+	for _, node := range nodes {
+		data, err := rp.Storage.DownloadChunk(context.Background(), hash, node)
+		if err != nil {
+			log.Printf("Failed to download chunk %s from %s: %v", hash, node, err)
+			continue
+		}
+		if utils.CalculateSHA256(data) != hash {
+			log.Printf("Warning: chunk %s on %s is corrupted", hash, node)
+			continue
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("no readable replica")
+}
+
+// candidates возвращает доступные серверы пула, не входящие в exclude,
+// в порядке предпочтения размещения для чанка
+func (rp *Repairer) candidates(hash string, exclude []string) []string {
+	used := make(map[string]bool, len(exclude))
+	for _, node := range exclude {
+		used[node] = true
+	}
+
+	var result []string
+	for _, node := range rp.Placement.Rank(hash, rp.StoragePool.Available()) {
+		if !used[node] {
+			result = append(result, node)
+		}
+	}
+
+	// Сначала серверы из failure domain, где еще нет копий чанка
+	if rp.DomainLevel != "" {
+		domains := rp.StoragePool.Domains()
+		occupied := make(map[string]bool, len(exclude))
+		for _, node := range exclude {
+			occupied[placement.DomainKey(domains, node, rp.DomainLevel)] = true
+		}
+		sort.SliceStable(result, func(i, j int) bool {
+			return !occupied[placement.DomainKey(domains, result[i], rp.DomainLevel)] &&
+				occupied[placement.DomainKey(domains, result[j], rp.DomainLevel)]
+		})
+	}
+	return result
+}
+
+// spreadViolated проверяет, что потеря одного failure domain не приводит
+// к потере чанка: копии должны находиться в разных domain, а шардов в одном
+// domain должно быть не больше, чем шардов четности
+func (rp *Repairer) spreadViolated(st *chunkstate.State) bool {
+	if rp.DomainLevel == "" {
+		return false
+	}
+
+	nodes, tolerated := st.Nodes, 1
+	if len(st.Info.Shards) > 0 {
+		nodes = make([]string, 0, len(st.Info.Shards))
+		for _, si := range st.Info.Shards {
+			nodes = append(nodes, si.NodeURL)
+		}
+		tolerated = len(st.Info.Shards) - st.Info.DataShards
+	}
+
+	domain, count := placement.Crowded(rp.StoragePool.Domains(), rp.DomainLevel, nodes)
+	if count <= tolerated {
+		return false
+	}
+	log.Printf("Warning: chunk %s has %d copies in %s %q", st.Hash, count, rp.DomainLevel, domain)
+	return true
+}
+
+// save изменяет размещение чанка во всех ссылающихся на него файлах и в
+// записи для дедупликации. Ссылки и размещение перечитываются в момент
+// записи: за время прохода копии могли переместить, а файлы - удалить.
+func (rp *Repairer) save(st *chunkstate.State, update func(replicas []metastore.ChunkInfo) []metastore.ChunkInfo) error {
+	return rp.Store.UpdateChunk(st.Hash, st.Refs, update)
+}
+
+// replaceReplicas возвращает список копий без копий на серверах lost
+// и с новыми копиями на серверах added
+func replaceReplicas(replicas []metastore.ChunkInfo, lost, added []string, hash string, size int64) []metastore.ChunkInfo {
+	result := make([]metastore.ChunkInfo, 0, len(replicas)+len(added))
+	nodes := make(map[string]bool, len(replicas)+len(added))
+	for _, ci := range replicas {
+		if !slices.Contains(lost, ci.NodeURL) {
+			result = append(result, ci)
+			nodes[ci.NodeURL] = true
+		}
+	}
+	for _, node := range added {
+		if !nodes[node] {
+			result = append(result, metastore.ChunkInfo{ChunkID: hash, NodeURL: node, Size: size})
+			nodes[node] = true
+		}
+	}
+	if len(result) == 0 {
+		// Без записей чанк пропал бы из файла: оставляем прежние
+		return replicas
+	}
+	return result
+}
+
+// replaceShards возвращает размещение, в котором восстановленные шарды
+// перенесены на новые серверы. Шард, который уже переместили на другой
+// сервер, не меняется.
+func replaceShards(replicas []metastore.ChunkInfo, moved map[int][2]string) []metastore.ChunkInfo {
+	result := make([]metastore.ChunkInfo, 0, len(replicas))
+	for _, ci := range replicas {
+		ci.Shards = append([]metastore.ShardInfo(nil), ci.Shards...)
+		for i, si := range ci.Shards {
+			if m, ok := moved[si.Index]; ok && si.NodeURL == m[0] {
+				ci.Shards[i].NodeURL = m[1]
+			}
+		}
+		result = append(result, ci)
+	}
+	return result
+}
+
+// queued проверяет, что чанк или один из его шардов стоит в очереди
+func queued(st *chunkstate.State, queue map[string]bool) bool {
+	if queue[st.Hash] {
+		return true
+	}
+	for _, si := range st.Info.Shards {
+		if queue[si.ShardID] {
+			return true
+		}
+	}
+	return false
+}
+
+// keys возвращает ключи множества
+func keys(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+	for k := range set {
+		list = append(list, k)
+	}
+	return list
+}
@@ -2,9 +2,14 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 )
 
 // Client интерфейс для взаимодействия с серверами хранения
@@ -12,8 +17,48 @@ type Client interface {
 	// UploadChunk загружает чанк на указанный сервер хранения
 	UploadChunk(chunkID, nodeURL string, data []byte) error
 
-	// DownloadChunk скачивает чанк с указанного сервера хранения
-	DownloadChunk(chunkID, nodeURL string) ([]byte, error)
+	// DownloadChunk скачивает чанк с указанного сервера хранения. Отмена ctx
+	// прерывает скачивание.
+	DownloadChunk(ctx context.Context, chunkID, nodeURL string) ([]byte, error)
+
+	// DownloadChunkRange возвращает поток с length байтами чанка начиная со смещения off.
+	// length < 0 означает чтение до конца чанка.
+	DownloadChunkRange(chunkID, nodeURL string, off, length int64) (io.ReadCloser, error)
+
+	// HasChunk проверяет, хранится ли чанк на указанном сервере
+	HasChunk(chunkID, nodeURL string) (bool, error)
+
+	// DeleteChunk удаляет чанк с указанного сервера хранения
+	DeleteChunk(chunkID, nodeURL string) error
+
+	// ListChunks возвращает до limit чанков сервера с ID больше after
+	// по возрастанию ID и значение after для следующей страницы
+	// (пусто, если страница последняя)
+	ListChunks(nodeURL, after string, limit int) ([]ChunkEntry, string, error)
+
+	// StreamChunks вызывает fn для каждого чанка сервера с ID больше after
+	// по возрастанию ID, получая список одним потоком
+	StreamChunks(nodeURL, after string, fn func(e ChunkEntry) error) error
+}
+
+// ChunkEntry запись о чанке в списке чанков сервера хранения
+type ChunkEntry struct {
+	ID      string    `json:"id"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// ChunkListEnd последняя запись потока NDJSON со списком чанков. Поток без
+// нее считается оборванным.
+type ChunkListEnd struct {
+	End   bool `json:"end"`
+	Count int  `json:"count"` // Количество чанков в потоке
+}
+
+// ChunkPage страница списка чанков сервера хранения
+type ChunkPage struct {
+	Chunks []ChunkEntry `json:"chunks"`
+	Next   string       `json:"next,omitempty"` // after для следующей страницы
 }
 
 // HTTPClient реализация Client для взаимодействия с серверами хранения через HTTP
@@ -52,10 +97,15 @@ func (c *HTTPClient) UploadChunk(chunkID, nodeURL string, data []byte) error {
 }
 
 // DownloadChunk скачивает чанк с указанного сервера хранения
-func (c *HTTPClient) DownloadChunk(chunkID, nodeURL string) ([]byte, error) {
+func (c *HTTPClient) DownloadChunk(ctx context.Context, chunkID, nodeURL string) ([]byte, error) {
 	url := fmt.Sprintf("%s/chunks/%s", nodeURL, chunkID)
 
-	resp, err := c.client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -67,3 +117,166 @@ func (c *HTTPClient) DownloadChunk(chunkID, nodeURL string) ([]byte, error) {
 
 	return io.ReadAll(resp.Body)
 }
+
+// DownloadChunkRange возвращает поток с length байтами чанка начиная со смещения off.
+// length < 0 означает чтение до конца чанка.
+func (c *HTTPClient) DownloadChunkRange(chunkID, nodeURL string, off, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	url := fmt.Sprintf("%s/chunks/%s", nodeURL, chunkID)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if length > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+length-1))
+	} else if off > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// Сервер не поддерживает Range: пропускаем начало и обрезаем хвост
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if length < 0 {
+			return resp.Body, nil
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, length), resp.Body}, nil
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download chunk range: %d", resp.StatusCode)
+	}
+}
+
+// HasChunk проверяет, хранится ли чанк на указанном сервере
+func (c *HTTPClient) HasChunk(chunkID, nodeURL string) (bool, error) {
+	url := fmt.Sprintf("%s/chunks/%s", nodeURL, chunkID)
+
+	resp, err := c.client.Head(url)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to check chunk: %d", resp.StatusCode)
+	}
+}
+
+// DeleteChunk удаляет чанк с указанного сервера хранения.
+// Отсутствие чанка на сервере не считается ошибкой.
+func (c *HTTPClient) DeleteChunk(chunkID, nodeURL string) error {
+	url := fmt.Sprintf("%s/chunks/%s", nodeURL, chunkID)
+
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete chunk: %d - %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// ListChunks возвращает страницу списка чанков сервера хранения
+func (c *HTTPClient) ListChunks(nodeURL, after string, limit int) ([]ChunkEntry, string, error) {
+	query := url.Values{}
+	if after != "" {
+		query.Set("after", after)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	resp, err := c.client.Get(nodeURL + "/chunks?" + query.Encode())
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to list chunks: %d", resp.StatusCode)
+	}
+
+	var page ChunkPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, "", fmt.Errorf("invalid chunk list: %w", err)
+	}
+	return page.Chunks, page.Next, nil
+}
+
+// StreamChunks получает полный список чанков сервера хранения в формате NDJSON.
+// Список считается полным, только если поток заканчивается записью ChunkListEnd.
+func (c *HTTPClient) StreamChunks(nodeURL, after string, fn func(e ChunkEntry) error) error {
+	query := url.Values{"format": {"ndjson"}}
+	if after != "" {
+		query.Set("after", after)
+	}
+
+	resp, err := c.client.Get(nodeURL + "/chunks?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to list chunks: %d", resp.StatusCode)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	count := 0
+	for {
+		var record struct {
+			ChunkEntry
+			ChunkListEnd
+		}
+		if err := dec.Decode(&record); err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("chunk list truncated after %d chunks", count)
+			}
+			return fmt.Errorf("invalid chunk list: %w", err)
+		}
+
+		if record.End {
+			if record.Count != count {
+				return fmt.Errorf("chunk list truncated: received %d of %d chunks", count, record.Count)
+			}
+			return nil
+		}
+
+		count++
+		if err := fn(record.ChunkEntry); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package utils
+
+import "time"
+
+// Throttle ограничивает среднюю скорость обработки данных
+type Throttle struct {
+	rate  int64 // Байт в секунду (0 - без ограничения)
+	start time.Time
+	total int64
+}
+
+// NewThrottle создает ограничитель на rate байт в секунду (0 - без ограничения)
+func NewThrottle(rate int64) *Throttle {
+	return &Throttle{rate: rate, start: time.Now()}
+}
+
+// Wait учитывает n обработанных байт и ждет, если обработка опережает лимит
+func (t *Throttle) Wait(n int64) {
+	if t.rate <= 0 {
+		return
+	}
+	t.total += n
+	due := time.Duration(float64(t.total) / float64(t.rate) * float64(time.Second))
+	if ahead := due - time.Since(t.start); ahead > 0 {
+		time.Sleep(ahead)
+	}
+}